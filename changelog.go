@@ -0,0 +1,210 @@
+package commonrepo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/gobwas/glob"
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+	"github.com/shakefu/commonrepo/pkg/repos"
+)
+
+// ErrUnknownUpstream is returned for a name which doesn't match any upstream
+var ErrUnknownUpstream = errors.New("no upstream matches")
+
+// Changelog is an upstream's commits between two refs, and which of them
+// change files in the composite
+type Changelog struct {
+	Upstream *repos.Repo
+	From     plumbing.Hash
+	To       plumbing.Hash
+	ToRef    plumbing.ReferenceName // What the to ref resolved to
+	Commits  []ChangelogCommit      // Newest first
+}
+
+// ChangelogCommit is an upstream commit and the files it changes in the
+// composite
+type ChangelogCommit struct {
+	repos.Commit
+	Targets []string // Destination paths the commit's files are written to
+}
+
+// Upstream returns the upstream whose URL, or the last part of it, is name.
+// Init must be called first.
+func (cr *CommonRepo) Upstream(name string) (upstream *repos.Repo, err error) {
+	found := cr.upstreamsNamed(name)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownUpstream, name)
+	}
+	return found[0].repo, nil
+}
+
+// upstreamsNamed returns every flattened upstream whose URL, or the last part
+// of it, is name
+func (cr *CommonRepo) upstreamsNamed(name string) (found []*CommonRepo) {
+	for _, each := range cr.flattened {
+		// We're the local repository, not an upstream
+		if each == cr {
+			continue
+		}
+		normal := gitutil.NormalizeURL(each.repo.URL)
+		if name == path.Base(normal) || gitutil.NormalizeURL(name) == normal {
+			found = append(found, each)
+		}
+	}
+	return
+}
+
+// Changelog returns the named upstream's commits reachable from the to ref but
+// not the from ref, marking the ones which change files written to the
+// composite, after the include, exclude and rename rules. An empty from is the
+// commit currently used, and an empty to is the upstream's newest version tag,
+// or its default branch. Init must be called first.
+func (cr *CommonRepo) Changelog(name string, from string, to string) (changelog Changelog, err error) {
+	found := cr.upstreamsNamed(name)
+	if len(found) == 0 {
+		return changelog, fmt.Errorf("%w: %s", ErrUnknownUpstream, name)
+	}
+	changelog.Upstream = found[0].repo
+	log, err := changelog.Upstream.Log(from, to)
+	if err != nil {
+		return
+	}
+	changelog.From, changelog.To, changelog.ToRef = log.From, log.To, log.ToRef
+
+	composite := cr.Composite()
+	for _, commit := range log.Commits {
+		entry := ChangelogCommit{Commit: commit}
+		for _, file := range commit.Files {
+			var destination string
+			if destination, err = cr.changedTarget(composite, found, file); err != nil {
+				return
+			}
+			if destination != "" {
+				entry.Targets = append(entry.Targets, destination)
+			}
+		}
+		changelog.Commits = append(changelog.Commits, entry)
+	}
+	return
+}
+
+// changedTarget returns where a change to the upstreams' original file would
+// show up in the composite, or an empty string if it wouldn't, because the
+// rules leave it out or a later upstream shadows it
+func (cr *CommonRepo) changedTarget(composite Composited, upstreams []*CommonRepo, file string) (destination string, err error) {
+	for _, each := range upstreams {
+		if destination, err = each.destinationFor(file); err != nil {
+			return "", err
+		}
+		if destination == "" {
+			continue
+		}
+		winner, ok := composite[destination]
+		switch {
+		case !ok, winner.Repo() == each.repo, isLayer(winner, each.repo, file):
+			return
+		case cr.order(each.repo) > cr.order(winner.Repo()):
+			// A new file which would shadow an earlier upstream's
+			return
+		}
+	}
+	return "", nil
+}
+
+// order returns the position of the repo in the flattened upstreams
+func (cr *CommonRepo) order(repo *repos.Repo) int {
+	for i, each := range cr.flattened {
+		if each.repo == repo {
+			return i
+		}
+	}
+	return -1
+}
+
+// destinationFor returns where the include, template, exclude, partial and
+// rename rules would write a file with the original name, or an empty string if
+// they leave it out. It works for files the cloned commit doesn't have.
+func (cr *CommonRepo) destinationFor(name string) (destination string, err error) {
+	trace := repos.Trace{Name: name}
+	if trace.Include, err = matching(cr.config.Include, name); err != nil {
+		return
+	}
+	if trace.Template, err = matching(cr.config.Template, name); err != nil {
+		return
+	}
+	if trace.Include == "" && trace.Template == "" {
+		return
+	}
+	if trace.Exclude, err = matching(cr.config.Exclude, name); err != nil || trace.Exclude != "" {
+		return
+	}
+	// Partials are only used by other templates
+	if trace.Partial, err = matching(cr.config.Partials, name); err != nil || trace.Partial != "" {
+		return
+	}
+	// The same renames as Init, then templates lose their suffix
+	trace.Renames = repos.TraceRenames(cr.config.Rename, name)
+	if strip, ok := cr.config.SuffixRename(); ok {
+		trace.Renames = append(trace.Renames,
+			repos.TraceRenames([]config.Rename{strip}, trace.Destination())...)
+	}
+	return trace.Destination(), nil
+}
+
+// matching returns the first of the globs which matches the name, or an empty
+// string if none do
+func matching(patterns []string, name string) (string, error) {
+	for _, pattern := range patterns {
+		g, err := glob.Compile(pattern, filepath.Separator)
+		if err != nil {
+			return "", err
+		}
+		if g.Match(name) {
+			return pattern, nil
+		}
+	}
+	return "", nil
+}
+
+// Write writes the changelog in a human readable form, with the commits which
+// change the composite starred and followed by the files they change
+func (changelog Changelog) Write(w io.Writer) (err error) {
+	changing := 0
+	for _, commit := range changelog.Commits {
+		if len(commit.Targets) > 0 {
+			changing++
+		}
+	}
+	to := changelog.To.String()[:7]
+	if changelog.ToRef != "" && !gitutil.IsHash(changelog.ToRef.String()) {
+		to += " (" + changelog.ToRef.Short() + ")"
+	}
+	if _, err = fmt.Fprintf(w, "%s %s..%s\n%d commits, %d changing this repository\n",
+		changelog.Upstream.URL, changelog.From.String()[:7], to,
+		len(changelog.Commits), changing); err != nil {
+		return
+	}
+
+	for _, commit := range changelog.Commits {
+		mark := " "
+		if len(commit.Targets) > 0 {
+			mark = "*"
+		}
+		if _, err = fmt.Fprintf(w, "%s %s %s (%s, %s)\n", mark, commit.Short(), commit.Subject,
+			commit.Author, commit.When.Format("2006-01-02")); err != nil {
+			return
+		}
+		for _, target := range commit.Targets {
+			if _, err = fmt.Fprintf(w, "    %s\n", target); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
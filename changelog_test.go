@@ -0,0 +1,104 @@
+package commonrepo
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	. "github.com/shakefu/commonrepo/internal/testutil"
+	"github.com/shakefu/commonrepo/pkg/config"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestChangelog(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Changelog", func() {
+		var fixture *Fixture
+		var upstream string
+		var readme plumbing.Hash
+		var cr *CommonRepo
+
+		g.Before(func() {
+			fixture = NewFixture("v1.0.0", "v1.1.0")
+			upstream = fixture.Upstream
+			readme = WriteFiles(upstream, "Add a readme", map[string]string{"README.md": "# Tagged\n"})
+			WriteFiles(fixture.Downstream, "Add config", map[string]string{
+				".commonrepo.yml": "upstream:\n  - url: " + upstream + "\n    ref: v1.0.0\n    include: [VERSION]\n",
+			})
+
+			var err error
+			if cr, err = New(fixture.Downstream); err != nil {
+				g.FailNow()
+			}
+			if err = cr.Init(); err != nil {
+				g.FailNow()
+			}
+		})
+
+		g.After(func() {
+			fixture.Remove()
+		})
+
+		g.It("finds upstreams by name", func() {
+			found, err := cr.Upstream(filepath.Base(upstream))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found.URL).To(Equal(upstream))
+
+			_, err = cr.Upstream("nope")
+			Expect(errors.Is(err, ErrUnknownUpstream)).To(BeTrue())
+		})
+
+		g.It("marks the commits which change the composite", func() {
+			changelog, err := cr.Changelog(filepath.Base(upstream), "", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changelog.From).To(Equal(fixture.Tags["v1.0.0"]))
+			Expect(changelog.To).To(Equal(fixture.Tags["v1.1.0"]))
+			Expect(changelog.Commits).To(HaveLen(1))
+			Expect(changelog.Commits[0].Targets).To(Equal([]string{"VERSION"}))
+
+			changelog, err = cr.Changelog(filepath.Base(upstream), "", readme.String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changelog.Commits).To(HaveLen(2))
+			Expect(changelog.Commits[0].Hash).To(Equal(readme))
+			Expect(changelog.Commits[0].Targets).To(BeEmpty())
+		})
+
+		g.It("writes which commits change the repository", func() {
+			changelog, err := cr.Changelog(filepath.Base(upstream), "", readme.String())
+			Expect(err).ToNot(HaveOccurred())
+			var buf bytes.Buffer
+			Expect(changelog.Write(&buf)).To(Succeed())
+			Expect(buf.String()).To(HavePrefix(upstream + " " + fixture.Tags["v1.0.0"].String()[:7] +
+				".." + readme.String()[:7] + "\n2 commits, 1 changing this repository\n"))
+			Expect(buf.String()).To(ContainSubstring("  " + readme.String()[:7] + " Add a readme (test, "))
+			Expect(buf.String()).To(ContainSubstring("* " + fixture.Tags["v1.1.0"].String()[:7] + " v1.1.0 (test, "))
+			Expect(buf.String()).To(HaveSuffix(")\n    VERSION\n"))
+		})
+
+		g.It("knows where files would be written", func() {
+			cfg, err := config.ParseConfig([]byte("include: ['**']\n" +
+				"exclude: ['*.md']\n" +
+				"partials: ['_partials/*']\n" +
+				"template-suffix: .tmpl\n" +
+				"rename: [{'deploy/(.*)': 'chart/%[1]s'}]\n"))
+			Expect(err).ToNot(HaveOccurred())
+			upstream := &CommonRepo{config: cfg}
+			for name, destination := range map[string]string{
+				"VERSION":                "VERSION",
+				"README.md":              "",
+				"_partials/header.tmpl":  "",
+				"deploy/values.yml.tmpl": "chart/values.yml",
+				"ci.yml.tmpl":            "ci.yml",
+			} {
+				Expect(upstream.destinationFor(name)).To(Equal(destination), name)
+			}
+		})
+	})
+}
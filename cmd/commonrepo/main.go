@@ -1,15 +1,25 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/shakefu/commonrepo"
+	"github.com/shakefu/commonrepo/pkg/common"
+	"github.com/shakefu/commonrepo/pkg/config"
 	"github.com/shakefu/commonrepo/pkg/gitutil"
+	"github.com/shakefu/commonrepo/pkg/lock"
 
 	"github.com/MakeNowJust/heredoc/v2"
 	"github.com/docopt/docopt-go"
+	"github.com/gobwas/glob"
 	"github.com/kataras/golog"
 )
 
@@ -24,8 +34,20 @@ var (
 	Usage     string
 )
 
+// Exit codes for the check command
+const (
+	ExitInSync  = 0
+	ExitDrifted = 1
+	ExitError   = 2
+)
+
+// ErrDrifted is returned by Check when managed files don't match upstream
+var ErrDrifted = errors.New("managed files have drifted from upstream")
+
 func init() {
 	golog.Default.TimeFormat = "2006-01-02 15:04:05.000"
+	// Log to stderr so command output like diffs can be piped cleanly
+	golog.SetOutput(os.Stderr)
 	// Enable global Debug output if Environment forces it
 	if os.Getenv("DEBUG") != "" {
 		golog.SetLevel("debug")
@@ -50,15 +72,37 @@ func init() {
 
 	// Create our CLI help string
 	usage := heredoc.Doc(`
-        Monitor replication status of mysql databases.
+        Composite files from upstream template repositories.
 
         Usage:
             %[1]s -h|--help
             %[1]s --version
-            %[1]s
+            %[1]s [options] [--dry-run]
+            %[1]s [options] diff
+            %[1]s [options] check
+            %[1]s [options] conflicts
+            %[1]s [options] explain <path>
+            %[1]s [options] graph [--format=<format>]
+            %[1]s [options] vendor
+            %[1]s [options] outdated [--json]
+            %[1]s [options] update [<name>] [--to=<ref>]
+            %[1]s [options] changelog <upstream> [<from>] [<to>]
 
         Options:
             -d, --debug                               show debug output
+            -n, --dry-run                             show what would change without writing
+            --format=<format>                         graph format: dot, mermaid or json [default: dot]
+            --frozen                                  fail if upstreams don't match the lockfile
+            --json                                    print outdated upstreams as JSON
+            --max-upstreams=<n>                       most upstreams to clone in total, 0 for no limit
+            --max-files=<n>                           most files all upstreams may hold, 0 for no limit
+            --max-bytes=<n>                           most bytes all upstreams may hold, 0 for no limit
+            --clone-timeout=<duration>                longest cloning each upstream may take, like 30s
+            --offline                                 load upstreams only from the vendor dir or cache
+            --prune                                   delete files upstreams no longer provide
+            --strict                                  fail on conflicts not declared in override
+            --to=<ref>                                ref to update the named upstream to
+            --vendor-dir=<dir>                        where vendor writes upstreams [default: .commonrepo/vendor]
             -h, --help                                show this help
             --version                                 show the version
     `)
@@ -69,15 +113,43 @@ func init() {
 
 // Args gives easy access and checking for our CLI
 type Args struct {
-	Debug   bool
-	Help    bool
-	Version bool
+	Changelog    bool
+	Check        bool
+	CloneTimeout string
+	Conflicts    bool
+	Diff         bool
+	Debug        bool
+	DryRun       bool
+	Explain      bool
+	Format       string
+	From         string `docopt:"<from>"`
+	Frozen       bool
+	Graph        bool
+	Help         bool
+	JSON         bool `docopt:"--json"`
+	MaxBytes     string
+	MaxFiles     string
+	MaxUpstreams string
+	Name         string `docopt:"<name>"`
+	Offline      bool
+	Outdated     bool
+	Path         string `docopt:"<path>"`
+	Prune        bool
+	Strict       bool
+	To           string
+	Until        string `docopt:"<to>"`
+	Update       bool
+	Upstream     string `docopt:"<upstream>"`
+	Vendor       bool
+	VendorDir    string
+	Version      bool
 }
 
 // GetArgs returns the CLI args as a struct
 func GetArgs(usage string, argv []string) (args *Args, err error) {
 	// Parsing CLI
-	parsed, err := docopt.ParseArgs(usage, argv, makeVersion())
+	parser := &docopt.Parser{HelpHandler: helpHandler(argv)}
+	parsed, err := parser.ParseArgs(usage, argv, makeVersion())
 	if err != nil {
 		return
 	}
@@ -96,33 +168,534 @@ func GetArgs(usage string, argv []string) (args *Args, err error) {
 	return
 }
 
+// helpHandler prints the usage like docopt does, except bad input to check exits
+// with ExitError, so CI can't mistake a typo for drift.
+func helpHandler(argv []string) func(err error, usage string) {
+	return func(err error, usage string) {
+		if err == nil {
+			docopt.PrintHelpAndExit(err, usage)
+			return
+		}
+		fmt.Fprintln(os.Stderr, usage)
+		if subcommand(argv) == "check" {
+			os.Exit(ExitError)
+		}
+		os.Exit(1)
+	}
+}
+
+// valueOptions are the options which take a value, which can be the next
+// argument
+var valueOptions = map[string]bool{
+	"--clone-timeout": true,
+	"--format":        true,
+	"--max-bytes":     true,
+	"--max-files":     true,
+	"--max-upstreams": true,
+	"--to":            true,
+	"--vendor-dir":    true,
+}
+
+// subcommand returns the first argument which isn't an option or an option's
+// value, which is the command if one was given
+func subcommand(argv []string) string {
+	for i := 0; i < len(argv); i++ {
+		switch arg := argv[i]; {
+		case arg == "--":
+			return append(argv[i+1:], "")[0]
+		case valueOptions[arg]:
+			i++
+		case !strings.HasPrefix(arg, "-"):
+			return arg
+		}
+	}
+	return ""
+}
+
 // Run actually executes the CLI once the args have been parsed and logs set and
 // all the other things that need to happen.
 func Run(args *Args) (err error) {
 	golog.Info("We're running")
-	err = DefaultRun()
+	if args.Check {
+		err = Check(args)
+		return
+	}
+	if args.Conflicts {
+		err = Conflicts(args)
+		return
+	}
+	if args.Diff {
+		err = Diff(args)
+		return
+	}
+	if args.Explain {
+		err = Explain(args)
+		return
+	}
+	if args.Graph {
+		err = Graph(args)
+		return
+	}
+	if args.Vendor {
+		err = Vendor(args)
+		return
+	}
+	if args.Outdated {
+		err = Outdated(args)
+		return
+	}
+	if args.Update {
+		err = Update(args)
+		return
+	}
+	if args.Changelog {
+		err = Changelog(args)
+		return
+	}
+	if args.DryRun {
+		err = DryRun(args)
+		return
+	}
+	err = DefaultRun(args)
 	return
 }
 
 // DefaultRun does a bunch of default settings ... mostly for testing
-// TODO: Add a dry-run flag
 // TODO: Add sensible logging across the whole thing
 // TODO: Debug why it just says "remote repository is empty"
-func DefaultRun() (err error) {
+func DefaultRun(args *Args) (err error) {
+	cr, composite, err := Load(args)
+	if err != nil {
+		return
+	}
+	if err = composite.Write(); err != nil {
+		return
+	}
+	if err = Prune(args, composite); err != nil {
+		return
+	}
+	// A frozen run already matches the lockfile, so there's nothing to update
+	if args.Frozen {
+		return
+	}
+	err = WriteLock(cr)
+	return
+}
+
+// Prune deletes files that upstreams stopped providing when --prune is given,
+// otherwise it warns about them.
+func Prune(args *Args, composite commonrepo.Composited) (err error) {
+	if args.Prune {
+		var pruned []string
+		if pruned, err = composite.Prune(); err != nil {
+			return
+		}
+		for _, name := range pruned {
+			golog.Infof("Pruned %s", name)
+		}
+		return
+	}
+
+	plan, err := composite.Plan()
+	if err != nil {
+		return
+	}
+	for _, change := range plan {
+		if change.Action == commonrepo.Stale {
+			golog.Warnf("%s is no longer provided by %s, run with --prune to delete it",
+				change.Name, change.Upstream)
+		}
+	}
+	return
+}
+
+// DryRun prints the plan of what DefaultRun would change without writing
+// anything.
+func DryRun(args *Args) (err error) {
+	_, composite, err := Load(args)
+	if err != nil {
+		return
+	}
+	plan, err := composite.Plan()
+	if err != nil {
+		return
+	}
+	err = plan.Summary(os.Stdout)
+	return
+}
+
+// Diff prints a unified diff of every file DefaultRun would change.
+func Diff(args *Args) (err error) {
+	_, composite, err := Load(args)
+	if err != nil {
+		return
+	}
+	plan, err := composite.Plan()
+	if err != nil {
+		return
+	}
+	err = plan.Diff(os.Stdout)
+	return
+}
+
+// Check verifies every managed file matches its upstream byte-for-byte, listing
+// the drifted paths and returning ErrDrifted if any don't.
+func Check(args *Args) (err error) {
+	_, composite, err := Load(args)
+	if err != nil {
+		return
+	}
+	plan, err := composite.Plan()
+	if err != nil {
+		return
+	}
+	if plan.InSync() {
+		fmt.Printf("%d managed files in sync\n", len(plan))
+		return
+	}
+	changed := plan.Changed()
+	for _, change := range changed {
+		fmt.Printf("%-10s %s (%s)\n", change.Action, change.Name, change.Upstream)
+	}
+	fmt.Printf("%d of %d managed files drifted\n", len(changed), len(plan))
+	return ErrDrifted
+}
+
+// Conflicts prints every target which is shadowed by another upstream or
+// rename, returning ErrConflicts for undeclared ones when running strict.
+func Conflicts(args *Args) (err error) {
+	cr, _, err := Load(args)
+	if err != nil {
+		return
+	}
+	conflicts, err := cr.Conflicts()
+	if err != nil {
+		return
+	}
+	for _, conflict := range conflicts {
+		fmt.Println(conflict)
+	}
+	undeclared := conflicts.Undeclared()
+	fmt.Printf("%d conflicts, %d undeclared\n", len(conflicts), len(undeclared))
+	if args.Strict && len(undeclared) > 0 {
+		return commonrepo.ErrConflicts
+	}
+	return
+}
+
+// Explain prints how every upstream's rules treated the given destination or
+// original path.
+func Explain(args *Args) (err error) {
+	cr, _, err := Load(args)
+	if err != nil {
+		return
+	}
+	explanation, err := cr.Explain(args.Path)
+	if err != nil {
+		return
+	}
+	err = explanation.Write(os.Stdout)
+	return
+}
+
+// Graph prints the inheritance graph of the upstreams in the requested format.
+func Graph(args *Args) (err error) {
+	cr, _, err := Load(args)
+	if err != nil {
+		return
+	}
+	graph, err := cr.Graph()
+	if err != nil {
+		return
+	}
+	err = graph.Write(os.Stdout, args.Format)
+	return
+}
+
+// Vendor snapshots every upstream into the vendor directory for running
+// offline.
+func Vendor(args *Args) (err error) {
+	cr, _, err := Load(args)
+	if err != nil {
+		return
+	}
+	vendored, err := cr.Vendor(common.VendorDir())
+	if err != nil {
+		return
+	}
+	for _, upstream := range vendored {
+		golog.Infof("Vendored %s@%s (%s)", upstream.URL, upstream.Resolved, upstream.Commit)
+	}
+	return
+}
+
+// Outdated prints every upstream in the config with the newest version tag it
+// has.
+func Outdated(args *Args) (err error) {
+	_, _, cfg, err := ReadConfig()
+	if err != nil {
+		return
+	}
+	list, err := commonrepo.ListOutdated(cfg)
+	if err != nil {
+		return
+	}
+	if args.JSON {
+		return list.WriteJSON(os.Stdout)
+	}
+	return list.Write(os.Stdout)
+}
+
+// Update rewrites the refs of outdated upstreams in the config to get their
+// newest version, or the named upstream's ref to the one given.
+func Update(args *Args) (err error) {
+	path, data, cfg, err := ReadConfig()
+	if err != nil {
+		return
+	}
+	list, err := commonrepo.ListOutdated(cfg)
+	if err != nil {
+		return
+	}
+	edited, updated, err := commonrepo.UpdateConfig(data, list, args.Name, args.To)
+	if errors.Is(err, commonrepo.ErrNoUpdate) && args.To == "" {
+		golog.Info("Upstreams are up to date")
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if err = os.WriteFile(path, edited, info.Mode()); err != nil {
+		return
+	}
+	for _, upstream := range updated {
+		golog.Infof("Updated %s to %s", upstream.Name, upstream.Ref)
+	}
+	return
+}
+
+// Changelog prints the upstream's commits between the locked commit, or the
+// given from ref, and the given to ref, or its newest version, marking the ones
+// which change files in this repository.
+func Changelog(args *Args) (err error) {
+	cr, _, err := Load(args)
+	if err != nil {
+		return
+	}
+	from := args.From
+	if from == "" {
+		if from, err = LockedCommit(cr, args.Upstream); err != nil {
+			return
+		}
+	}
+	changelog, err := cr.Changelog(args.Upstream, from, args.Until)
+	if err != nil {
+		return
+	}
+	err = changelog.Write(os.Stdout)
+	return
+}
+
+// LockedCommit returns the commit the lockfile has for the named upstream, or
+// an empty string if there's no lockfile or it doesn't have the upstream.
+func LockedCommit(cr *commonrepo.CommonRepo, name string) (commit string, err error) {
+	upstream, err := cr.Upstream(name)
+	if err != nil {
+		return
+	}
+	repoRoot, err := gitutil.FindLocalRepoPath()
+	if err != nil {
+		return
+	}
+	locked, err := lock.Read(osfs.New(repoRoot), lock.FileName)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return
+	}
+	for _, each := range locked.Upstream {
+		if gitutil.NormalizeURL(each.URL) == gitutil.NormalizeURL(upstream.URL) {
+			return each.Commit, nil
+		}
+	}
+	return
+}
+
+// ReadConfig returns the path, contents and parsed config file in the
+// repository root as it is on disk, which may not be committed yet.
+func ReadConfig() (path string, data []byte, cfg *config.Config, err error) {
 	repoRoot, err := gitutil.FindLocalRepoPath()
 	if err != nil {
 		return
 	}
-	cr, err := commonrepo.New(repoRoot)
+	pattern, err := glob.Compile(common.ConfigFileGlob(), '/')
 	if err != nil {
 		return
 	}
-	err = cr.Init()
+	entries, err := os.ReadDir(repoRoot)
 	if err != nil {
 		return
 	}
-	composite := cr.Composite()
-	err = composite.Write()
+	// Like finding it in a repo, the shortest name wins
+	for _, entry := range entries {
+		if entry.IsDir() || !pattern.Match(entry.Name()) {
+			continue
+		}
+		if path == "" || len(entry.Name()) < len(filepath.Base(path)) {
+			path = filepath.Join(repoRoot, entry.Name())
+		}
+	}
+	if path == "" {
+		err = errors.New("no config file found")
+		return
+	}
+	if data, err = os.ReadFile(path); err != nil {
+		return
+	}
+	cfg, err = config.ParseConfig(data)
+	return
+}
+
+// CheckConflicts warns about every undeclared conflict between upstreams,
+// returning ErrConflicts when running strict.
+func CheckConflicts(args *Args, cr *commonrepo.CommonRepo) (err error) {
+	conflicts, err := cr.Conflicts()
+	if err != nil {
+		return
+	}
+	undeclared := conflicts.Undeclared()
+	for _, conflict := range undeclared {
+		golog.Warnf("%s", conflict)
+	}
+	if args.Strict && len(undeclared) > 0 {
+		err = fmt.Errorf("%w, add them to override: to allow them", commonrepo.ErrConflicts)
+	}
+	return
+}
+
+// Load initializes the local repository's CommonRepo and returns it along with
+// its composited targets, verifying the lockfile first when running frozen and
+// checking for conflicts between upstreams.
+func Load(args *Args) (cr *commonrepo.CommonRepo, composite commonrepo.Composited, err error) {
+	repoRoot, err := gitutil.FindLocalRepoPath()
+	if err != nil {
+		return
+	}
+	// The vendor directory is relative to the repository root
+	vendorDir := args.VendorDir
+	if !filepath.IsAbs(vendorDir) {
+		vendorDir = filepath.Join(repoRoot, vendorDir)
+	}
+	common.SetVendorDir(vendorDir)
+	common.SetOffline(args.Offline)
+	if cr, err = commonrepo.New(repoRoot); err != nil {
+		return
+	}
+	if err = SetLimits(args, cr); err != nil {
+		return
+	}
+	if err = cr.Init(); err != nil {
+		return
+	}
+	if err = LogVersions(cr); err != nil {
+		return
+	}
+	if args.Frozen {
+		if err = VerifyLock(cr); err != nil {
+			return
+		}
+	}
+	// The conflicts command reports them itself
+	if !args.Conflicts {
+		if err = CheckConflicts(args, cr); err != nil {
+			return
+		}
+	}
+	composite = cr.Composite()
+	return
+}
+
+// LogVersions logs the tag each upstream with a version range resolved to.
+func LogVersions(cr *commonrepo.CommonRepo) (err error) {
+	graph, err := cr.Graph()
+	if err != nil {
+		return
+	}
+	for _, node := range graph.Nodes {
+		if version, _ := config.VersionRange(node.Ref); version == nil {
+			continue
+		}
+		golog.Infof("Resolved %s@%s to %s", node.URL, node.Ref,
+			plumbing.ReferenceName(node.Resolved).Short())
+	}
+	return
+}
+
+// SetLimits overrides the default limits on loading upstreams with any given
+// on the command line.
+func SetLimits(args *Args, cr *commonrepo.CommonRepo) (err error) {
+	if args.MaxUpstreams != "" {
+		if cr.MaxUpstreams, err = strconv.Atoi(args.MaxUpstreams); err != nil {
+			return fmt.Errorf("invalid --max-upstreams: %w", err)
+		}
+	}
+	if args.MaxFiles != "" {
+		if cr.MaxFiles, err = strconv.Atoi(args.MaxFiles); err != nil {
+			return fmt.Errorf("invalid --max-files: %w", err)
+		}
+	}
+	if args.MaxBytes != "" {
+		if cr.MaxBytes, err = strconv.ParseInt(args.MaxBytes, 10, 64); err != nil {
+			return fmt.Errorf("invalid --max-bytes: %w", err)
+		}
+	}
+	if args.CloneTimeout != "" {
+		if cr.CloneTimeout, err = time.ParseDuration(args.CloneTimeout); err != nil {
+			return fmt.Errorf("invalid --clone-timeout: %w", err)
+		}
+	}
+	return
+}
+
+// VerifyLock returns an error if the resolved upstreams don't match the
+// lockfile in the repository root.
+func VerifyLock(cr *commonrepo.CommonRepo) (err error) {
+	repoRoot, err := gitutil.FindLocalRepoPath()
+	if err != nil {
+		return
+	}
+	locked, err := lock.Read(osfs.New(repoRoot), lock.FileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("frozen run requires %s, run without --frozen to create it", lock.FileName)
+		}
+		return
+	}
+	resolved, err := cr.Lock()
+	if err != nil {
+		return
+	}
+	err = locked.Verify(resolved)
+	return
+}
+
+// WriteLock writes the resolved upstreams to the lockfile in the repository
+// root.
+func WriteLock(cr *commonrepo.CommonRepo) (err error) {
+	repoRoot, err := gitutil.FindLocalRepoPath()
+	if err != nil {
+		return
+	}
+	resolved, err := cr.Lock()
+	if err != nil {
+		return
+	}
+	err = resolved.Write(osfs.New(repoRoot), lock.FileName)
 	return
 }
 
@@ -135,7 +708,20 @@ func main() {
 	}
 
 	// Invoke the actual work
-	if err = Run(args); err != nil {
+	err = Run(args)
+	if args.Check {
+		// Check uses distinct exit codes so CI can tell drift from failure
+		switch {
+		case err == nil:
+			os.Exit(ExitInSync)
+		case errors.Is(err, ErrDrifted):
+			os.Exit(ExitDrifted)
+		default:
+			golog.Child(BinName).Error(err)
+			os.Exit(ExitError)
+		}
+	}
+	if err != nil {
 		golog.Child(BinName).Fatal(err)
 	}
 }
@@ -3,12 +3,13 @@
 package commonrepo
 
 import (
-	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"sync"
+	"time"
 
 	"go.uber.org/multierr"
 
@@ -17,13 +18,18 @@ import (
 	"github.com/shakefu/commonrepo/pkg/common"
 	"github.com/shakefu/commonrepo/pkg/config"
 	"github.com/shakefu/commonrepo/pkg/gitutil"
+	"github.com/shakefu/commonrepo/pkg/lock"
 	"github.com/shakefu/commonrepo/pkg/repos"
 )
 
 // CommonRepo provides the top level interface for operations
 type CommonRepo struct {
-	// Options which can be changed at runtime
-	MaxUpstreamDepth int // How deep we will keep cloning upstreams (default: 5)
+	// Options which can be changed at runtime, limits of zero aren't enforced
+	MaxUpstreamDepth int           // How deep we will keep cloning upstreams (default: 5)
+	MaxUpstreams     int           // How many upstreams we will clone in total (default: 10)
+	MaxFiles         int           // How many files all the upstreams may hold (default: 10000)
+	MaxBytes         int64         // How many bytes all the upstreams may hold (default: 256MiB)
+	CloneTimeout     time.Duration // How long cloning each upstream may take (default: 2m)
 	// Internal
 	repo      *repos.Repo    // The repo cloned as a source
 	config    *config.Config // The configuration loaded from the repo
@@ -63,6 +69,10 @@ func NewFrom(from string, url string, ref ...string) (cr *CommonRepo, err error)
 // This might be useless but I added it anyway. I'll delete it later if I don't
 // need it.
 func NewFromRepo(from string, repo *repos.Repo) (cr *CommonRepo, err error) {
+	// Remember which file the search found, rather than the search
+	if from, err = repo.FindConfig(from); err != nil {
+		return
+	}
 	config, err := repo.LoadConfig(from)
 	if err != nil {
 		return
@@ -135,7 +145,9 @@ func (cr *CommonRepo) Init() (err error) {
 	}
 	// Get the flattened list of upstreams
 	// TODO: Skip this if already populated?
-	cr.flattened = cr.FlattenUpstreams()
+	if cr.flattened, err = cr.FlattenUpstreams(); err != nil {
+		return
+	}
 
 	// Composite all our template vars into a single map
 	templateVars := make(map[string]interface{}, 16)
@@ -145,6 +157,16 @@ func (cr *CommonRepo) Init() (err error) {
 		}
 	}
 
+	// Templates are told where they're from and where they're going
+	meta, err := cr.templateContext()
+	if err != nil {
+		return
+	}
+
+	// Every upstream's partials go in one set, so later upstreams can redefine
+	// earlier ones
+	partials := repos.NewTemplate("partials")
+
 	// Apply the configs to each repo
 	for _, each := range cr.flattened {
 		if _, err = each.repo.ApplyIncludes(each.config.Include); err != nil {
@@ -155,12 +177,51 @@ func (cr *CommonRepo) Init() (err error) {
 			return
 		}
 
+		if err = each.repo.ApplyDelims(each.config.Delims); err != nil {
+			return
+		}
+
+		if err = each.repo.ApplyPartials(each.config.Partials, partials); err != nil {
+			return
+		}
+
 		if _, err = each.repo.ApplyExcludes(each.config.Exclude); err != nil {
 			return
 		}
 
+		if err = each.repo.ApplyPolicies(each.config.Policies); err != nil {
+			return
+		}
+
 		each.repo.ApplyRenames(each.config.Rename)
+		// Templates lose their suffix after every other rename
+		if strip, ok := each.config.SuffixRename(); ok {
+			each.repo.ApplyRenames([]config.Rename{strip})
+		}
+		each.repo.ApplyTemplateContext(meta)
+	}
+	return
+}
+
+// templateContext returns what every template gets to know about the
+// repository being written to and the upstreams
+func (cr *CommonRepo) templateContext() (meta repos.TemplateContext, err error) {
+	for _, each := range cr.flattened {
+		if each != cr {
+			meta.Upstreams = append(meta.Upstreams, each.repo.TemplateUpstream())
+		}
 	}
+
+	// A remote repository is written from the ref it was cloned at
+	if !gitutil.IsLocal(cr.repo.URL) {
+		meta.Downstream = path.Base(gitutil.NormalizeURL(cr.repo.URL))
+		meta.DefaultBranch = cr.repo.Ref
+		return
+	}
+	if meta.Downstream, err = gitutil.RepoName(cr.repo.URL); err != nil {
+		return
+	}
+	meta.DefaultBranch, err = gitutil.LocalDefaultBranch(cr.repo.URL)
 	return
 }
 
@@ -175,97 +236,67 @@ func (cr *CommonRepo) Upstreams() (upstreams []*CommonRepo, err error) {
 	}
 	// Ensure we've cached the flattened structure for easy reference
 	if len(cr.flattened) == 0 {
-		cr.flattened = cr.FlattenUpstreams()
+		if cr.flattened, err = cr.FlattenUpstreams(); err != nil {
+			return
+		}
 	}
 	// Return the list of flattened upstreams
 	upstreams = cr.flattened
 	return
 }
 
-// LoadUpstreams recursively clones all the upstream repositories
+// LoadUpstreams recursively clones all the upstream repositories. Upstreams
+// inherited by more than one downstream are only cloned and loaded once, and
+// upstreams which inherit each other return an ErrUpstreamCycle. Exceeding any
+// of the limits on upstreams, files, bytes or clone time returns an
+// ErrLimitExceeded naming the upstream.
 func (cr *CommonRepo) LoadUpstreams(depth int) (errs error) {
-	var cloning sync.WaitGroup
-	var repo *repos.Repo
-	var err error
-
-	// Terminating condition, we delved too greedily and too deep and awoke the
-	// flame in the darkness
-	if depth < 1 {
-		return errors.New("maximum recursion depth reached")
-	}
-
-	// Terminating condition, we don't have any Upstream repositories to clone
-	if len(cr.config.Upstream) == 0 {
-		return
-	}
-
-	// Preallocate the list of upstreams
-	cr.upstreams = make([]*CommonRepo, len(cr.config.Upstream))
-
-	// Iterate through the upstreams in the config
-	for i, upstream := range cr.config.Upstream {
-		cloning.Add(1)
-
-		// Schedule asynchronous cloning of the repository
-		go func(upstream config.Upstream, i int) {
-			defer cloning.Done()
-
-			// Create a new Repo, initialize and clone it
-			if repo, err = repos.New(upstream.URL, upstream.Ref); err != nil {
-				errs = multierr.Append(errs, err)
-				return
-			}
-
-			// Try to find a commonrepo config file, while applying the renames
-			// we have defined in the parent's config for this upstream, if any
-			if cr.upstreams[i], err = NewFromRename(repo, upstream.Rename); err != nil {
-				errs = multierr.Append(errs, err)
-				return
-			}
-
-			// Yeet this off into the scheduler
-			cloning.Add(1)
-			defer func(cr *CommonRepo) {
-				defer cloning.Done()
-
-				// Descend another layer into cloning
-				err := cr.LoadUpstreams(depth - 1)
-				if err != nil {
-					errs = multierr.Append(errs, err)
-					return
-				}
-			}(cr.upstreams[i])
-		}(upstream, i)
-	}
-	cloning.Wait()
+	load := newLoader(cr)
+	load.load(cr, depth)
+	load.loading.Wait()
 	// Ideally by the time we get here, the recursive cloning is done, yay
-	return
+	if load.errs != nil {
+		return load.errs
+	}
+	return cr.cycle()
 }
 
 // FlattenUpstreams returns a slice of all the upstreams flattened into the
-// inherited order.
+// inherited order, with each upstream appearing once before everything which
+// inherits it, and ending with ourselves.
 //
 // This will mutate the config of the CommonRepo and its upstreams in order to
 // apply the downstream include/exclude/rename rules.
-func (cr *CommonRepo) FlattenUpstreams() (upstreams []*CommonRepo) {
-	// If we don't have anything at all, just return the empty list
+func (cr *CommonRepo) FlattenUpstreams() (upstreams []*CommonRepo, err error) {
+	// If we don't have anything at all, just return the list of ourselves
 	if cr.upstreams == nil {
-		return []*CommonRepo{cr}
+		return []*CommonRepo{cr}, nil
 	}
 
-	// Preallocate the list of upstreams to the minimum length that it can be
-	upstreams = make([]*CommonRepo, 0, len(cr.upstreams)+1)
+	linearized, err := cr.linearize(make(map[*CommonRepo][]*CommonRepo))
+	if err != nil {
+		return
+	}
 
-	// Iterate through the upstreams that we inherit
-	for i, upstream := range cr.upstreams {
-		// Mutate the upstream's config to apply the downstream rules
-		upstream.AppendConfig(&cr.config.Upstream[i])
-		// Append the upstreams to our upstreams
-		upstreams = append(upstreams, upstream.FlattenUpstreams()...)
+	// The linearization puts what takes precedence first, but we apply the
+	// upstreams in the reverse of that
+	upstreams = make([]*CommonRepo, len(linearized))
+	for i, upstream := range linearized {
+		upstreams[len(linearized)-1-i] = upstream
 	}
 
-	// And finally append ourselves
-	upstreams = append(upstreams, cr)
+	// Mutate the upstreams' configs to apply the downstream rules. Upstreams
+	// are only shared by downstreams adding the same rules, so they're applied
+	// once.
+	applied := make(map[*CommonRepo]bool)
+	for _, downstream := range upstreams {
+		for i, upstream := range downstream.upstreams {
+			if !applied[upstream] {
+				applied[upstream] = true
+				upstream.AppendConfig(&downstream.config.Upstream[i])
+			}
+		}
+	}
 	return
 }
 
@@ -274,6 +305,13 @@ func (cr *CommonRepo) AppendConfig(parent *config.Upstream) {
 	cr.config.Include = append(cr.config.Include, parent.Include...)
 	cr.config.Exclude = append(cr.config.Exclude, parent.Exclude...)
 	cr.config.Rename = append(cr.config.Rename, parent.Rename...)
+	// The parent's upstream-wide policy goes before its per-glob rules so the
+	// more specific rules still win
+	if parent.Policy != "" {
+		cr.config.Policies = append(cr.config.Policies,
+			config.PolicyRule{Glob: "**", Policy: parent.Policy})
+	}
+	cr.config.Policies = append(cr.config.Policies, parent.Policies...)
 }
 
 // String satisifes the stringer interface and returns repo/from@ref
@@ -285,6 +323,7 @@ func (cr *CommonRepo) String() string {
 // names to their contents
 func (cr *CommonRepo) Composite() Composited {
 	composited := make(Composited)
+	rules := cr.mergeRules()
 
 	// Iterate through all of our commonrepos in order
 	for _, each := range cr.flattened {
@@ -293,15 +332,57 @@ func (cr *CommonRepo) Composite() Composited {
 		// fmt.Println(targets)
 		// And mapping them to their paths
 		for name := range targets {
-			composited[name] = targets[name]
+			target := targets[name]
+			// Targets with a merge rule keep what they're replacing as
+			// layers, otherwise the last upstream wins
+			if rule := findMergeRule(rules, name); rule != nil {
+				var below *repos.Target
+				if existing, ok := composited[name]; ok {
+					below = &existing
+				}
+				target = layer(target, below, rule)
+			}
+			composited[name] = target
 		}
 	}
 
 	return composited
 }
 
+// openTarget creates the parent paths for fullName and opens it for writing
+// with the target's permissions, while holding the given lock
+func openTarget(fs billy.Filesystem, fullName string, target repos.Target, mkdir *sync.Mutex) (handle billy.File, err error) {
+	mkdir.Lock()
+	defer mkdir.Unlock()
+
+	if err = fs.MkdirAll(filepath.Dir(fullName), 0755); err != nil {
+		return
+	}
+
+	// Get the info so we can create the file with the right permissions
+	var info os.FileInfo
+	if info, err = target.Stat(); err != nil {
+		return
+	}
+
+	return fs.OpenFile(fullName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+}
+
+// managed returns the manifest entry for a change that was written
+func managed(change Change) lock.ManagedFile {
+	return lock.ManagedFile{
+		Upstream: change.Upstream,
+		Hash:     lock.Hash(change.Content),
+		Block:    isBlock(change.Name, change.Target),
+	}
+}
+
 func (cr *CommonRepo) setDefaultOptions() {
 	cr.MaxUpstreamDepth = 5
+	cr.MaxUpstreams = 10
+	cr.MaxFiles = 10000
+	cr.MaxBytes = 256 << 20
+	cr.CloneTimeout = 2 * time.Minute
 }
 
 // Composited exists as a type just so we can mount the Write* methods on it
@@ -326,19 +407,28 @@ func (composite Composited) Write() (err error) {
 
 // WriteFS writes the composite to the given filesystem
 func (composite Composited) WriteFS(fs billy.Filesystem, basePaths ...string) (errs error) {
-	var base string
-	var err error
-	if basePaths == nil || len(basePaths) < 1 {
-		// Default to the local repository root.
-		// TODO: This should not default to the repo root - instead we should
-		// give it a filesystem that is defaulted to the repo root so you can't
-		// write outside it
-		base, err = gitutil.FindLocalRepoPath()
-		if err != nil {
-			return multierr.Append(errs, err)
-		}
-	} else {
-		base = basePaths[0]
+	// Default to the local repository root.
+	// TODO: This should not default to the repo root - instead we should
+	// give it a filesystem that is defaulted to the repo root so you can't
+	// write outside it
+	base, err := composite.basePath(basePaths)
+	if err != nil {
+		return multierr.Append(errs, err)
+	}
+
+	// Load the manifest of what we wrote last time, so we can carry forward
+	// anything that's gone stale but hasn't been pruned yet
+	manifest, err := readManifest(fs, base)
+	if err != nil {
+		return multierr.Append(errs, err)
+	}
+
+	// Figure out what actually needs writing, which renders every target
+	// before we touch the filesystem, so a broken template doesn't leave
+	// behind a truncated file
+	plan, err := composite.PlanFS(fs, base)
+	if err != nil {
+		return multierr.Append(errs, err)
 	}
 
 	// We're going to try to do this asynchronously, for no other reason than
@@ -346,62 +436,78 @@ func (composite Composited) WriteFS(fs billy.Filesystem, basePaths ...string) (e
 	// copies.
 	var copying sync.WaitGroup
 	var mkdir sync.Mutex
+	var record sync.Mutex
+	written := make(map[string]lock.ManagedFile, len(plan))
+	fail := func(err error) {
+		record.Lock()
+		defer record.Unlock()
+		errs = multierr.Append(errs, err)
+	}
+
+	// Iterate over all our changes and write them to the given filesystem
+	for _, change := range plan {
+		switch change.Action {
+		case Unchanged:
+			record.Lock()
+			written[change.Name] = managed(change)
+			record.Unlock()
+			continue
+		case Skip, Stale:
+			// Keep whatever we knew about it from last time
+			record.Lock()
+			if file, ok := manifest.Files[change.Name]; ok {
+				written[change.Name] = file
+			}
+			record.Unlock()
+			continue
+		}
 
-	// Iterate over all our targets and write them to the given filesystem
-	for name, target := range composite {
 		copying.Add(1)
-		go func(name string, target repos.Target) {
+		go func(change Change) {
 			defer copying.Done()
+			var err error
 
 			// Get the fullName which we will write to eventually
-			fullName := filepath.Join(base, name)
+			fullName := filepath.Join(base, change.Name)
 			fullName = filepath.Clean(fullName)
 
 			// TODO: Determine if we want to wall off writing outside the repo root
 			// There's definitely a use case, e.g. for composing dotfiles into the
 			// home directory, but there's also a lot of risk.
 
-			// Make sure the write target path exists... we have to lock this
-			// because multiple files could be trying to create the parent paths
-			// simultaneously which the filesystem doesn't like at all
-			mkdir.Lock()
-			if err = fs.MkdirAll(filepath.Dir(fullName), 0755); err != nil {
-				mkdir.Unlock()
-				errs = multierr.Append(errs, err)
-				return
-			}
-			mkdir.Unlock()
-
-			// Get the info so we can create the file with the right permissions
-			var info os.FileInfo
-			if info, err = target.Stat(); err != nil {
-				errs = multierr.Append(errs, err)
-				return
-			}
-			var mode = info.Mode()
-
-			// TODO: This won't work if we decide we want to do a YAML merge
-			// Probably need to wrap the Mkdir/Stat/Open stuff to check for file
-			// existence, etc.
-			// TODO: Add flag to prevent overwriting files (optionally)
-			// Copy the file data
+			// Make sure the write target path exists and open the file... we
+			// have to lock this because multiple files could be trying to
+			// create the parent paths simultaneously which the filesystem
+			// doesn't like at all, and memfs isn't safe for concurrent use
 			var handle billy.File
-			handle, err = fs.OpenFile(fullName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+			handle, err = openTarget(fs, fullName, change.Target, &mkdir)
 			if err != nil {
-				errs = multierr.Append(errs, err)
+				fail(err)
 				return
 			}
 			defer handle.Close()
 
-			err = target.Write(handle)
-			if err != nil {
-				errs = multierr.Append(errs, err)
+			if _, err = handle.Write(change.Content); err != nil {
+				fail(err)
 				return
 			}
-		}(name, target)
+
+			// Remember what we wrote for the manifest
+			record.Lock()
+			written[change.Name] = managed(change)
+			record.Unlock()
+		}(change)
 	}
 
 	copying.Wait()
+	if errs != nil {
+		return
+	}
+
+	manifest.Files = written
+	if err = manifest.Write(fs, manifestPath(base)); err != nil {
+		return multierr.Append(errs, err)
+	}
 
 	return
 }
@@ -2,10 +2,12 @@ package commonrepo
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-billy/v5/osfs"
@@ -14,6 +16,7 @@ import (
 	"github.com/shakefu/commonrepo/pkg/config"
 	"github.com/shakefu/commonrepo/pkg/files"
 	"github.com/shakefu/commonrepo/pkg/gitutil"
+	"github.com/shakefu/commonrepo/pkg/lock"
 	"github.com/shakefu/commonrepo/pkg/repos"
 
 	. "github.com/onsi/gomega"
@@ -116,16 +119,133 @@ func TestCommonRepoInternal(t *testing.T) {
 				err = cr.LoadUpstreams(4)
 				Expect(err).ToNot(HaveOccurred())
 			})
+
+			g.It("errors with the full path of a cycle", func() {
+				cr, err := NewFrom("testdata/fixtures/cycle/a.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				err = cr.LoadUpstreams(4)
+				Expect(errors.Is(err, ErrUpstreamCycle)).To(BeTrue())
+				Expect(err.Error()).To(MatchRegexp(
+					`^upstream cycle: \./testdata/fixtures/cycle/a\.yml@\S+ -> ` +
+						`\./testdata/fixtures/cycle/b\.yml@\S+ -> ` +
+						`\./testdata/fixtures/cycle/a\.yml@\S+$`))
+			})
+
+			g.It("errors when there are too many upstreams", func() {
+				cr, err := NewFrom("testdata/fixtures/graph/downstream.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				cr.MaxUpstreams = 2
+				err = cr.LoadUpstreams(4)
+				Expect(errors.Is(err, ErrLimitExceeded)).To(BeTrue())
+				Expect(err.Error()).To(Equal(
+					"upstream limit exceeded: cloning . would exceed 2 upstreams"))
+			})
+
+			g.It("errors when upstreams have too many files", func() {
+				cr, err := NewFrom("testdata/fixtures/local/single.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				cr.MaxFiles = 10
+				err = cr.LoadUpstreams(4)
+				Expect(errors.Is(err, ErrLimitExceeded)).To(BeTrue())
+				Expect(err.Error()).To(MatchRegexp(
+					`^upstream limit exceeded: \. brings the upstreams to \d+ files, over the limit of 10$`))
+			})
+
+			g.It("errors when upstreams are too big", func() {
+				cr, err := NewFrom("testdata/fixtures/local/single.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				cr.MaxBytes = 1024
+				err = cr.LoadUpstreams(4)
+				Expect(errors.Is(err, ErrLimitExceeded)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("over the limit of 1024"))
+			})
+
+			g.It("errors when cloning takes too long", func() {
+				cr, err := NewFrom("testdata/fixtures/local/single.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				cr.CloneTimeout = time.Nanosecond
+				err = cr.LoadUpstreams(4)
+				Expect(errors.Is(err, ErrLimitExceeded)).To(BeTrue())
+				Expect(err.Error()).To(Equal(
+					"upstream limit exceeded: cloning . took longer than 1ns"))
+			})
+
+			g.It("doesn't enforce limits of zero", func() {
+				cr, err := NewFrom("testdata/fixtures/graph/downstream.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				cr.MaxUpstreams, cr.MaxFiles, cr.MaxBytes, cr.CloneTimeout = 0, 0, 0, 0
+				Expect(cr.LoadUpstreams(4)).To(Succeed())
+			})
+
+			g.It("shares upstreams inherited more than once", func() {
+				cr, err := NewFrom("testdata/fixtures/graph/downstream.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				err = cr.LoadUpstreams(4)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cr.upstreams).To(HaveLen(2))
+				left, right := cr.upstreams[0], cr.upstreams[1]
+				Expect(left.upstreams[0]).To(BeIdenticalTo(right.upstreams[0]))
+			})
 		})
 
 		g.Describe("FlattenUpstreams", func() {
+			g.It("flattens a shared upstream once, before everything inheriting it", func() {
+				cr, err := NewFrom("testdata/fixtures/graph/downstream.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				err = cr.LoadUpstreams(4)
+				Expect(err).ToNot(HaveOccurred())
+				upstreams, err := cr.FlattenUpstreams()
+				Expect(err).ToNot(HaveOccurred())
+				configs := []string{}
+				for _, upstream := range upstreams {
+					configs = append(configs, upstream.from)
+				}
+				Expect(configs).To(Equal([]string{
+					"testdata/fixtures/graph/base.yml",
+					"testdata/fixtures/graph/left.yml",
+					"testdata/fixtures/graph/right.yml",
+					"testdata/fixtures/graph/downstream.yml",
+				}))
+				// Both downstreams add the same rules, so they're applied once
+				Expect(upstreams[0].config.Include).To(HaveLen(2))
+				Expect(upstreams[0].config.Rename).To(HaveLen(1))
+			})
+
+			g.It("keeps each downstream's rules to its own copy of an upstream", func() {
+				cr, err := NewFrom("testdata/fixtures/diamond/downstream.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cr.Init()).To(Succeed())
+				Expect(cr.flattened).To(HaveLen(5))
+				Expect(cr.flattened[0].from).To(Equal(cr.flattened[2].from))
+				Expect(cr.flattened[0]).ToNot(BeIdenticalTo(cr.flattened[2]))
+				// Left excludes two.txt and right excludes one.txt, so a union
+				// of their rules would leave nothing
+				Expect(Keys(cr.Composite())).To(Equal([]string{
+					"right/two.txt",
+					"testdata/fixtures/diamond/one.txt",
+				}))
+				conflicts, err := cr.Conflicts()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conflicts).To(BeEmpty())
+			})
+
+			g.It("errors when upstreams are listed before what they inherit", func() {
+				cr, err := NewFrom("testdata/fixtures/graph/inconsistent.yml", ".")
+				Expect(err).ToNot(HaveOccurred())
+				err = cr.LoadUpstreams(4)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = cr.FlattenUpstreams()
+				Expect(errors.Is(err, ErrUpstreamOrder)).To(BeTrue())
+			})
+
 			g.It("works with a single repo", func() {
 				cr, err := NewFrom("testdata/fixtures/local/single.yml", ".")
 				Expect(err).ToNot(HaveOccurred())
 				Expect(cr).ToNot(BeNil())
 				err = cr.LoadUpstreams(4)
 				Expect(err).ToNot(HaveOccurred())
-				upstreams := cr.FlattenUpstreams()
+				upstreams, err := cr.FlattenUpstreams()
+				Expect(err).ToNot(HaveOccurred())
 				Expect(len(upstreams)).To(Equal(2))
 				Expect(upstreams[1].config.Include).To(
 					Equal([]string{"testdata/fixtures/local/single.yml"}))
@@ -139,7 +259,8 @@ func TestCommonRepoInternal(t *testing.T) {
 				Expect(cr).ToNot(BeNil())
 				err = cr.LoadUpstreams(4)
 				Expect(err).ToNot(HaveOccurred())
-				upstreams := cr.FlattenUpstreams()
+				upstreams, err := cr.FlattenUpstreams()
+				Expect(err).ToNot(HaveOccurred())
 				Expect(len(upstreams)).To(Equal(4))
 				// Multi config
 				Expect(upstreams[3].config.Include).To(
@@ -162,7 +283,8 @@ func TestCommonRepoInternal(t *testing.T) {
 				Expect(cr).ToNot(BeNil())
 				err = cr.LoadUpstreams(4)
 				Expect(err).ToNot(HaveOccurred())
-				upstreams := cr.FlattenUpstreams()
+				upstreams, err := cr.FlattenUpstreams()
+				Expect(err).ToNot(HaveOccurred())
 				Expect(len(upstreams)).To(Equal(5))
 				// Deep config
 				Expect(upstreams[4].config.Include).To(
@@ -188,7 +310,8 @@ func TestCommonRepoInternal(t *testing.T) {
 				Expect(cr).ToNot(BeNil())
 				err = cr.LoadUpstreams(4)
 				Expect(err).ToNot(HaveOccurred())
-				upstreams := cr.FlattenUpstreams()
+				upstreams, err := cr.FlattenUpstreams()
+				Expect(err).ToNot(HaveOccurred())
 				Expect(len(upstreams)).To(Equal(2))
 				// Append config
 				append := upstreams[1]
@@ -264,6 +387,7 @@ func TestCommonRepoInternal(t *testing.T) {
 				found, err := files.List(fs)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(found).To(Equal([]string{
+					".commonrepo.manifest",
 					"testdata/out/.not_commonrepo.yml",
 					"testdata/out/empty.yml",
 					"testdata/out/single.yml",
@@ -285,6 +409,7 @@ func TestCommonRepoInternal(t *testing.T) {
 				found, err := files.List(fs)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(found).To(Equal([]string{
+					".commonrepo.manifest",
 					"testdata/out/.not_commonrepo.yml",
 					"testdata/out/empty.yml",
 					"testdata/out/single.yml",
@@ -314,6 +439,7 @@ func TestCommonRepoInternal(t *testing.T) {
 				composite := cr.Composite()
 				Expect(composite).ToNot(BeNil())
 				defer os.RemoveAll(outPath)
+				defer os.Remove(filepath.Join(repoPath, lock.ManifestFileName))
 				err = composite.Write()
 				Expect(err).ToNot(HaveOccurred())
 				fs := osfs.New(outPath)
@@ -1,13 +1,15 @@
 package commonrepo_test
 
 import (
+	"path/filepath"
 	"testing"
 
 	. "github.com/shakefu/commonrepo"
+	"github.com/shakefu/commonrepo/pkg/repos"
 
 	. "github.com/onsi/gomega"
+	. "github.com/shakefu/commonrepo/internal/testutil"
 	"github.com/shakefu/goblin"
-	// . "github.com/shakefu/commonrepo/internal/testutil"
 )
 
 func TestCommonRepo(t *testing.T) {
@@ -26,5 +28,113 @@ func TestCommonRepo(t *testing.T) {
 				Expect(ups[0].String()).To(HavePrefix("./@"))
 			})
 		})
+
+		g.Describe("Init", func() {
+			var fixture *Fixture
+
+			g.Before(func() {
+				fixture = NewFixture("v1.0.0", "v2.3.1")
+				WriteFiles(fixture.Upstream, "Add a template", map[string]string{
+					"templates/ci.yml": "# managed by {{ .CommonRepo.Name }}@{{ .CommonRepo.Resolved }}" +
+						" from {{ .CommonRepo.Source }} to {{ .CommonRepo.Destination }}\n" +
+						"# {{ .CommonRepo.Downstream }} on {{ .CommonRepo.DefaultBranch }}" +
+						" with {{ len .CommonRepo.Upstreams }} upstream\n" +
+						"# {{ .CommonRepo.Commit | trunc 7 }}\n",
+					".commonrepo.yml": "template: [templates/ci.yml]\n" +
+						"rename: [{'templates/(.*)': '.github/workflows/%[1]s'}]\n",
+				})
+				TagCommit(fixture.Upstream, "v2.4.0", WriteFiles(fixture.Upstream, "v2.4.0",
+					map[string]string{"VERSION": "v2.4.0"}))
+				WriteFiles(fixture.Downstream, "Add config", map[string]string{
+					".commonrepo.yml": "upstream:\n  - url: " + fixture.Upstream + "\n    ref: ^2.3\n",
+				})
+			})
+
+			g.After(func() {
+				fixture.Remove()
+			})
+
+			g.It("gives templates the context", func() {
+				cr, err := New(fixture.Downstream)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cr.Init()).To(Succeed())
+				target, ok := cr.Composite()[".github/workflows/ci.yml"]
+				Expect(ok).To(BeTrue())
+				rendered, err := target.Bytes()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(rendered)).To(HavePrefix(
+					"# managed by " + filepath.Base(fixture.Upstream) + "@v2.4.0" +
+						" from templates/ci.yml to .github/workflows/ci.yml\n" +
+						"# " + filepath.Base(fixture.Downstream) + " on master with 1 upstream\n"))
+				Expect(string(rendered)).To(MatchRegexp("\n# [0-9a-f]{7}\n$"))
+			})
+		})
+
+		g.Describe("partials", func() {
+			var fixture *Fixture
+
+			g.Before(func() {
+				fixture = NewFixture("v1.0.0")
+				WriteFiles(fixture.Upstream, "Add files", map[string]string{
+					".commonrepo.yml":       "template: [ci.yml]\npartials: [_partials/*]\n",
+					"_partials/header.tmpl": "# from {{ .CommonRepo.Name }}",
+					"ci.yml":                "{{ template \"header\" . }}\n",
+				})
+				WriteFiles(fixture.Downstream, "Add files", map[string]string{
+					".commonrepo.yml":              "partials: [.github/partials/*]\nupstream:\n  - url: " + fixture.Upstream + "\n",
+					".github/partials/header.tmpl": "# ours",
+				})
+			})
+
+			g.After(func() {
+				fixture.Remove()
+			})
+
+			g.It("lets downstreams redefine upstream partials", func() {
+				cr, err := New(fixture.Downstream)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cr.Init()).To(Succeed())
+				composite := cr.Composite()
+				Expect(composite).ToNot(HaveKey("_partials/header.tmpl"))
+				target := composite["ci.yml"]
+				Expect(target.Bytes()).To(BeEquivalentTo("# ours\n"))
+			})
+		})
+
+		g.Describe("template suffix", func() {
+			var fixture *Fixture
+
+			g.Before(func() {
+				fixture = NewFixture("v1.0.0")
+				WriteFiles(fixture.Upstream, "Add files", map[string]string{
+					".commonrepo.yml":        "include: ['**']\nexclude: ['.commonrepo.yml', VERSION]\ntemplate-suffix: .tmpl\n",
+					"README.md.tmpl":         "# {{ .name }}\n",
+					"deploy/values.yml.tmpl": "name: {{ .name }}\n",
+					"plain.yml":              "name: {{ .name }}\n",
+				})
+				WriteFiles(fixture.Downstream, "Add config", map[string]string{
+					".commonrepo.yml": "template-vars: {name: down}\nupstream:\n  - url: " + fixture.Upstream + "\n" +
+						"    rename: [{'deploy/(.*)': 'chart/%[1]s'}]\n",
+				})
+			})
+
+			g.After(func() {
+				fixture.Remove()
+			})
+
+			g.It("renders suffixed files and strips the suffix after renames", func() {
+				cr, err := New(fixture.Downstream)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cr.Init()).To(Succeed())
+				composite := cr.Composite()
+				Expect(repos.SortTargetNames(composite)).To(Equal([]string{"README.md", "chart/values.yml", "plain.yml"}))
+				readme := composite["README.md"]
+				Expect(readme.Bytes()).To(BeEquivalentTo("# down\n"))
+				values := composite["chart/values.yml"]
+				Expect(values.Bytes()).To(BeEquivalentTo("name: down\n"))
+				plain := composite["plain.yml"]
+				Expect(plain.Bytes()).To(BeEquivalentTo("name: {{ .name }}\n"))
+			})
+		})
 	})
 }
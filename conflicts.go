@@ -0,0 +1,128 @@
+package commonrepo
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/gobwas/glob"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+	"github.com/shakefu/commonrepo/pkg/repos"
+)
+
+// Conflict is a target which was shadowed by another target with the same
+// destination, from a later upstream or a rename within the same one
+type Conflict struct {
+	Name     string       // Destination path
+	Winner   repos.Target // The target which is written
+	Loser    repos.Target // The target which was shadowed
+	Declared bool         // Whether an override glob expects it
+}
+
+// String returns the conflict as a human readable line
+func (conflict Conflict) String() string {
+	line := fmt.Sprintf("%s: %s (%s) shadows %s (%s)", conflict.Name,
+		conflict.Winner.Repo(), conflict.Winner.Name,
+		conflict.Loser.Repo(), conflict.Loser.Name)
+	if conflict.Declared {
+		line += " [override]"
+	}
+	return line
+}
+
+// Conflicts is the list of shadowed targets, sorted by name
+type Conflicts []Conflict
+
+// Undeclared returns only the conflicts which no override glob expects
+func (conflicts Conflicts) Undeclared() Conflicts {
+	undeclared := make(Conflicts, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		if !conflict.Declared {
+			undeclared = append(undeclared, conflict)
+		}
+	}
+	return undeclared
+}
+
+// ErrConflicts is returned when upstreams shadow each other without an
+// override declaring it
+var ErrConflicts = errors.New("undeclared conflicts between upstreams")
+
+// Conflicts returns every target which was shadowed by another with the same
+// destination. Targets with a merge rule don't conflict since every layer is
+// used.
+func (cr *CommonRepo) Conflicts() (conflicts Conflicts, err error) {
+	if len(cr.flattened) == 0 {
+		return nil, errors.New("upstreams not initialized")
+	}
+
+	var overrides []glob.Glob
+	if overrides, err = cr.overrides(); err != nil {
+		return
+	}
+
+	// Collect everything that provided each destination, in the order they
+	// were applied, so the last one is the winner
+	providers := make(map[string][]repos.Target)
+	for _, each := range cr.flattened {
+		for _, shadowed := range each.repo.Shadowed() {
+			providers[shadowed.Name] = append(providers[shadowed.Name], shadowed.Target)
+		}
+		targets := each.repo.Targets()
+		for _, name := range repos.SortTargetNames(targets) {
+			providers[name] = append(providers[name], targets[name])
+		}
+	}
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rules := cr.mergeRules()
+	conflicts = Conflicts{}
+	for _, name := range names {
+		targets := providers[name]
+		if len(targets) < 2 || findMergeRule(rules, name) != nil {
+			continue
+		}
+		declared := false
+		for _, override := range overrides {
+			if override.Match(name) {
+				declared = true
+				break
+			}
+		}
+		winner := targets[len(targets)-1]
+		for _, loser := range targets[:len(targets)-1] {
+			if sameSource(winner, loser) {
+				continue
+			}
+			conflicts = append(conflicts, Conflict{name, winner, loser, declared})
+		}
+	}
+	return
+}
+
+// sameSource returns true if both targets are the same file from the same
+// commit, which happens when an upstream is inherited with different rules
+func sameSource(a repos.Target, b repos.Target) bool {
+	return a.Name == b.Name && a.Repo().Commit() == b.Repo().Commit() &&
+		gitutil.NormalizeURL(a.Repo().URL) == gitutil.NormalizeURL(b.Repo().URL)
+}
+
+// overrides returns the compiled override globs from every upstream
+func (cr *CommonRepo) overrides() (overrides []glob.Glob, err error) {
+	for _, each := range cr.flattened {
+		for _, pattern := range each.config.Override {
+			var g glob.Glob
+			if g, err = glob.Compile(pattern, filepath.Separator); err != nil {
+				return
+			}
+			overrides = append(overrides, g)
+		}
+	}
+	return
+}
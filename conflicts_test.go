@@ -0,0 +1,73 @@
+package commonrepo
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestConflicts(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Conflicts", func() {
+		var conflicts Conflicts
+
+		g.Before(func() {
+			cr, err := NewFrom("testdata/fixtures/conflicts/downstream.yml", ".")
+			if err != nil {
+				g.FailNow()
+			}
+			if err = cr.Init(); err != nil {
+				g.FailNow()
+			}
+			if conflicts, err = cr.Conflicts(); err != nil {
+				g.FailNow()
+			}
+		})
+
+		g.It("requires Init", func() {
+			cr, err := NewFrom("testdata/fixtures/conflicts/downstream.yml", ".")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = cr.Conflicts()
+			Expect(err).To(MatchError("upstreams not initialized"))
+		})
+
+		g.It("finds every shadowed target", func() {
+			Expect(conflicts).To(HaveLen(3))
+			names := []string{}
+			for _, conflict := range conflicts {
+				names = append(names, conflict.Name)
+			}
+			Expect(names).To(Equal([]string{"declared.yml", "shared.yml", "shared.yml"}))
+		})
+
+		g.It("reports later upstreams shadowing earlier ones", func() {
+			conflict := conflicts[1]
+			Expect(conflict.Winner.Name).To(Equal("testdata/fixtures/conflicts/renamed.yml"))
+			Expect(conflict.Loser.Name).To(Equal("testdata/fixtures/conflicts/theirs.yml"))
+			Expect(conflict.Declared).To(BeFalse())
+		})
+
+		g.It("reports renames shadowing targets in the same upstream", func() {
+			conflict := conflicts[2]
+			Expect(conflict.Winner.Name).To(Equal("testdata/fixtures/conflicts/renamed.yml"))
+			Expect(conflict.Loser.Name).To(Equal("testdata/fixtures/conflicts/mine.yml"))
+			Expect(conflict.Winner.Repo()).To(Equal(conflict.Loser.Repo()))
+		})
+
+		g.It("marks declared overrides", func() {
+			Expect(conflicts[0].Declared).To(BeTrue())
+			Expect(conflicts[0].String()).To(HaveSuffix(" [override]"))
+			Expect(conflicts.Undeclared()).To(HaveLen(2))
+		})
+
+		g.It("has a readable string", func() {
+			Expect(conflicts[1].String()).To(MatchRegexp(
+				`^shared\.yml: \.@\S+ \(testdata/fixtures/conflicts/renamed\.yml\) ` +
+					`shadows \.@\S+ \(testdata/fixtures/conflicts/theirs\.yml\)$`))
+		})
+	})
+}
@@ -0,0 +1,147 @@
+package commonrepo
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/utils/binary"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Diff writes a git-style unified diff of every change in the plan, comparing
+// the local files to the rendered targets
+func (plan Plan) Diff(w io.Writer) (err error) {
+	encoder := fdiff.NewUnifiedEncoder(w, fdiff.DefaultContextLines)
+	return encoder.Encode(planPatch(plan.Changed()))
+}
+
+// planPatch adapts a Plan to the go-git diff Patch interface so we can reuse
+// its unified encoder
+type planPatch Plan
+
+func (patch planPatch) FilePatches() []fdiff.FilePatch {
+	patches := make([]fdiff.FilePatch, 0, len(patch))
+	for _, change := range patch {
+		patches = append(patches, newChangePatch(change))
+	}
+	return patches
+}
+
+func (patch planPatch) Message() string {
+	return ""
+}
+
+// changePatch is the diff for a single Change
+type changePatch struct {
+	from   fdiff.File
+	to     fdiff.File
+	binary bool
+	chunks []fdiff.Chunk
+}
+
+// newChangePatch computes the line diff between the local and rendered content
+func newChangePatch(change Change) *changePatch {
+	patch := &changePatch{}
+
+	// We don't track the local file mode, so assume it matches the target to
+	// avoid noisy mode changes in the output
+	mode := filemode.Regular
+	if change.Action != Stale {
+		if info, err := change.Target.Stat(); err == nil {
+			if m, err := filemode.NewFromOSFileMode(info.Mode()); err == nil {
+				mode = m
+			}
+		}
+	}
+	// Stale files are deleted, unless we only managed blocks within them
+	if change.Action != Stale || change.Content != nil {
+		patch.to = &patchFile{change.Name, mode, change.Content}
+	}
+	if change.Existing != nil {
+		patch.from = &patchFile{change.Name, mode, change.Existing}
+	}
+
+	patch.binary = isBinary(change.Content) || isBinary(change.Existing)
+	if patch.binary {
+		return patch
+	}
+
+	for _, d := range diff.Do(string(change.Existing), string(change.Content)) {
+		var op fdiff.Operation
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			op = fdiff.Equal
+		case diffmatchpatch.DiffInsert:
+			op = fdiff.Add
+		case diffmatchpatch.DiffDelete:
+			op = fdiff.Delete
+		}
+		patch.chunks = append(patch.chunks, &patchChunk{d.Text, op})
+	}
+	return patch
+}
+
+func (patch *changePatch) IsBinary() bool {
+	return patch.binary
+}
+
+func (patch *changePatch) Files() (from, to fdiff.File) {
+	// Interfaces holding a nil pointer aren't nil, so be explicit
+	if patch.from != nil {
+		from = patch.from
+	}
+	if patch.to != nil {
+		to = patch.to
+	}
+	return
+}
+
+func (patch *changePatch) Chunks() []fdiff.Chunk {
+	return patch.chunks
+}
+
+// patchFile is one side of a changePatch
+type patchFile struct {
+	path    string
+	mode    filemode.FileMode
+	content []byte
+}
+
+func (file *patchFile) Hash() plumbing.Hash {
+	return plumbing.ComputeHash(plumbing.BlobObject, file.content)
+}
+
+func (file *patchFile) Mode() filemode.FileMode {
+	return file.mode
+}
+
+func (file *patchFile) Path() string {
+	return file.path
+}
+
+// patchChunk is a single contiguous operation within a changePatch
+type patchChunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (chunk *patchChunk) Content() string {
+	return chunk.content
+}
+
+func (chunk *patchChunk) Type() fdiff.Operation {
+	return chunk.op
+}
+
+// isBinary returns true if the content looks like a binary file
+func isBinary(content []byte) bool {
+	if content == nil {
+		return false
+	}
+	found, err := binary.IsBinary(bytes.NewReader(content))
+	return err == nil && found
+}
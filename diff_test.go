@@ -0,0 +1,77 @@
+package commonrepo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestDiff(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Diff", func() {
+		var composite Composited
+		var fs billy.Filesystem
+		var buf *bytes.Buffer
+
+		g.Before(func() {
+			cr, err := NewFrom("testdata/fixtures/templating.yml", ".")
+			if err != nil {
+				g.FailNow()
+			}
+			if err = cr.Init(); err != nil {
+				g.FailNow()
+			}
+			composite = cr.Composite()
+		})
+
+		g.BeforeEach(func() {
+			fs = memfs.New()
+			buf = new(bytes.Buffer)
+		})
+
+		g.It("shows new files", func() {
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.Diff(buf)).To(Succeed())
+			Expect(buf.String()).To(HavePrefix("diff --git a/templated.yml b/templated.yml\nnew file mode 100644\n"))
+			Expect(buf.String()).To(ContainSubstring("--- /dev/null\n+++ b/templated.yml\n"))
+			Expect(buf.String()).To(ContainSubstring("+templated: true\n"))
+		})
+
+		g.It("shows rendered line changes", func() {
+			data := []byte("project: commonrepo\nversion: 0.1.0\ntemplated: true\n")
+			Expect(util.WriteFile(fs, "templated.yml", data, 0644)).To(Succeed())
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.Diff(buf)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("--- a/templated.yml\n+++ b/templated.yml\n"))
+			Expect(buf.String()).To(ContainSubstring("-version: 0.1.0\n+version: 1.0.0\n"))
+		})
+
+		g.It("shows stale files as deleted", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			plan, err := Composited{}.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.Diff(buf)).To(Succeed())
+			Expect(buf.String()).To(HavePrefix("diff --git a/templated.yml b/templated.yml\ndeleted file mode 100644\n"))
+			Expect(buf.String()).To(ContainSubstring("-templated: true\n"))
+		})
+
+		g.It("is empty when nothing changes", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.Diff(buf)).To(Succeed())
+			Expect(buf.String()).To(BeEmpty())
+		})
+	})
+}
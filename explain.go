@@ -0,0 +1,153 @@
+package commonrepo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/shakefu/commonrepo/pkg/repos"
+)
+
+// ErrUnknownPath is returned when explaining a path which no upstream has
+var ErrUnknownPath = errors.New("no upstream has the path")
+
+// Explanation describes how every upstream treated a destination path, or a
+// file with that original name
+type Explanation struct {
+	Path    string   // The path being explained
+	Sources []Source // Every upstream file involved, in the flattened order
+}
+
+// Source is how a single upstream treated one of its files
+type Source struct {
+	Upstream    *repos.Repo   // The upstream the file is in
+	Order       int           // Position of the upstream in the flattened order
+	Trace       repos.Trace   // What the upstream's config did to the file
+	Destination string        // Where the file would be written, empty if it isn't a target
+	Merged      bool          // Whether it's merged with the other upstreams' files
+	ShadowedBy  *repos.Target // The target written instead, if it was shadowed
+}
+
+// Result returns a short description of what happened to the file
+func (source Source) Result() string {
+	switch {
+	case source.Destination == "" && source.Trace.Exclude != "":
+		return "excluded"
+	case source.Destination == "" && source.Trace.Partial != "":
+		return "partial"
+	case source.Destination == "":
+		return "not included"
+	case source.ShadowedBy != nil:
+		return fmt.Sprintf("shadowed by %s (%s)", source.ShadowedBy.Repo(), source.ShadowedBy.Name)
+	case source.Merged:
+		return "merged into " + source.Destination
+	}
+	return "written to " + source.Destination
+}
+
+// Explain traces how each upstream's include, template, exclude and rename
+// rules treated the path, which may be either a destination path or an
+// original file name in an upstream. Init must be called first.
+func (cr *CommonRepo) Explain(path string) (explanation Explanation, err error) {
+	if len(cr.flattened) == 0 {
+		return explanation, errors.New("upstreams not initialized")
+	}
+
+	explanation.Path = path
+	composite := cr.Composite()
+	for order, each := range cr.flattened {
+		for _, file := range each.repo.Files() {
+			destination := destinationOf(each.repo, file)
+			if file != path && destination != path {
+				continue
+			}
+
+			source := Source{
+				Upstream:    each.repo,
+				Order:       order,
+				Destination: destination,
+			}
+			source.Trace, _ = each.repo.Trace(file)
+
+			if destination != "" {
+				winner := composite[destination]
+				written := winner.Repo() == each.repo && winner.Name == file
+				switch {
+				case winner.Merge != nil && (written || isLayer(winner, each.repo, file)):
+					source.Merged = true
+				case !written:
+					source.ShadowedBy = &winner
+				}
+			}
+			explanation.Sources = append(explanation.Sources, source)
+		}
+	}
+
+	if len(explanation.Sources) == 0 {
+		err = fmt.Errorf("%w: %s", ErrUnknownPath, path)
+	}
+	return
+}
+
+// destinationOf returns where the repo's original file ended up, or an empty
+// string if it isn't a target
+func destinationOf(repo *repos.Repo, file string) string {
+	for name, target := range repo.Targets() {
+		if target.Name == file {
+			return name
+		}
+	}
+	// Shadowed by a rename within the same upstream
+	for _, shadowed := range repo.Shadowed() {
+		if shadowed.Target.Name == file {
+			return shadowed.Name
+		}
+	}
+	return ""
+}
+
+// isLayer returns true if the repo's file is one of the target's layers
+func isLayer(target repos.Target, repo *repos.Repo, file string) bool {
+	for _, layer := range target.Layers {
+		if layer.Repo() == repo && layer.Name == file {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the explanation in a human readable form
+func (explanation Explanation) Write(w io.Writer) (err error) {
+	if _, err = fmt.Fprintln(w, explanation.Path); err != nil {
+		return
+	}
+	for _, source := range explanation.Sources {
+		lines := []string{
+			fmt.Sprintf("%d. %s", source.Order+1, source.Upstream),
+			fmt.Sprintf("   %-9s %s", "file", source.Trace.Name),
+		}
+		if source.Trace.Include != "" {
+			lines = append(lines, fmt.Sprintf("   %-9s %q", "include", source.Trace.Include))
+		}
+		if source.Trace.Template != "" {
+			lines = append(lines, fmt.Sprintf("   %-9s %q", "template", source.Trace.Template))
+		}
+		if source.Trace.Exclude != "" {
+			lines = append(lines, fmt.Sprintf("   %-9s %q", "exclude", source.Trace.Exclude))
+		}
+		if source.Trace.Partial != "" {
+			lines = append(lines, fmt.Sprintf("   %-9s %q", "partial", source.Trace.Partial))
+		}
+		for _, renamed := range source.Trace.Renames {
+			lines = append(lines, fmt.Sprintf("   %-9s %s -> %s", "rename", renamed.Rename.String(), renamed.Name))
+		}
+		lines = append(lines, fmt.Sprintf("   %-9s %s", "result", source.Result()))
+
+		for _, line := range lines {
+			if _, err = fmt.Fprintln(w, line); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
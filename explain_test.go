@@ -0,0 +1,108 @@
+package commonrepo
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestExplain(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Explain", func() {
+		var cr *CommonRepo
+
+		g.Before(func() {
+			var err error
+			if cr, err = NewFrom("testdata/fixtures/explain.yml", "."); err != nil {
+				g.FailNow()
+			}
+			if err = cr.Init(); err != nil {
+				g.FailNow()
+			}
+		})
+
+		g.It("requires Init", func() {
+			uninit, err := NewFrom("testdata/fixtures/explain.yml", ".")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = uninit.Explain("template.yml")
+			Expect(err).To(MatchError("upstreams not initialized"))
+		})
+
+		g.It("traces templates and renames", func() {
+			explanation, err := cr.Explain("template.yml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(explanation.Sources).To(HaveLen(1))
+			source := explanation.Sources[0]
+			Expect(source.Trace.Name).To(Equal("testdata/fixtures/templates/template.yml"))
+			Expect(source.Trace.Include).To(BeEmpty())
+			Expect(source.Trace.Template).To(Equal("testdata/fixtures/templates/template.yml"))
+			Expect(source.Trace.Renames).To(HaveLen(1))
+			Expect(source.Trace.Renames[0].Name).To(Equal("template.yml"))
+			Expect(source.Result()).To(Equal("written to template.yml"))
+		})
+
+		g.It("traces original names", func() {
+			explanation, err := cr.Explain("testdata/fixtures/conflicts/mine.yml")
+			Expect(err).ToNot(HaveOccurred())
+			source := explanation.Sources[0]
+			Expect(source.Trace.Include).To(Equal("testdata/fixtures/conflicts/*.yml"))
+			Expect(source.Destination).To(Equal("mine.yaml"))
+		})
+
+		g.It("traces excludes", func() {
+			explanation, err := cr.Explain("testdata/fixtures/conflicts/theirs.yml")
+			Expect(err).ToNot(HaveOccurred())
+			source := explanation.Sources[0]
+			Expect(source.Trace.Include).To(Equal("testdata/fixtures/conflicts/*.yml"))
+			Expect(source.Trace.Exclude).To(Equal("**/theirs.yml"))
+			Expect(source.Result()).To(Equal("excluded"))
+		})
+
+		g.It("traces files which aren't included", func() {
+			explanation, err := cr.Explain("README.md")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(explanation.Sources[0].Result()).To(Equal("not included"))
+		})
+
+		g.It("errors for unknown paths", func() {
+			_, err := cr.Explain("nope/nothing.yml")
+			Expect(err).To(MatchError(ErrUnknownPath))
+		})
+
+		g.It("traces shadowed targets", func() {
+			conflicted, err := NewFrom("testdata/fixtures/conflicts/downstream.yml", ".")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conflicted.Init()).To(Succeed())
+			explanation, err := conflicted.Explain("shared.yml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(explanation.Sources).To(HaveLen(3))
+			Expect(explanation.Sources[0].Order).To(Equal(0))
+			Expect(explanation.Sources[0].Trace.Name).To(Equal("testdata/fixtures/conflicts/theirs.yml"))
+			Expect(explanation.Sources[0].Result()).To(HavePrefix("shadowed by "))
+			Expect(explanation.Sources[0].Result()).To(HaveSuffix("(testdata/fixtures/conflicts/renamed.yml)"))
+			Expect(explanation.Sources[1].Trace.Name).To(Equal("testdata/fixtures/conflicts/mine.yml"))
+			Expect(explanation.Sources[1].ShadowedBy).ToNot(BeNil())
+			Expect(explanation.Sources[2].Trace.Name).To(Equal("testdata/fixtures/conflicts/renamed.yml"))
+			Expect(explanation.Sources[2].Result()).To(Equal("written to shared.yml"))
+		})
+
+		g.It("writes a readable explanation", func() {
+			explanation, err := cr.Explain("template.yml")
+			Expect(err).ToNot(HaveOccurred())
+			buf := new(bytes.Buffer)
+			Expect(explanation.Write(buf)).To(Succeed())
+			Expect(buf.String()).To(MatchRegexp(`^template\.yml
+1\. \.@\S+
+   file      testdata/fixtures/templates/template\.yml
+   template  "testdata/fixtures/templates/template\.yml"
+   rename    testdata/fixtures/templates/\(\.\*\): %\[1\]s -> template\.yml
+   result    written to template\.yml
+$`))
+		})
+	})
+}
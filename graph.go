@@ -0,0 +1,189 @@
+package commonrepo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownFormat is returned for a graph format other than dot, mermaid or
+// json
+var ErrUnknownFormat = errors.New("unknown graph format")
+
+// Graph is the inheritance graph of the upstreams, with the root repository as
+// the first node
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+	Order []int       `json:"order"` // Node IDs in the flattened order
+}
+
+// GraphNode is a single upstream in the graph
+type GraphNode struct {
+	ID       int    `json:"id"`
+	URL      string `json:"url"`
+	Ref      string `json:"ref"`      // Requested ref
+	Resolved string `json:"resolved"` // Full reference name Ref resolved to
+	Commit   string `json:"commit"`
+	Config   string `json:"config"`  // Path of the config file in the upstream
+	Files    int    `json:"files"`   // Number of files in the upstream
+	Targets  int    `json:"targets"` // Number of files it contributes
+	Order    int    `json:"order"`   // Position in the flattened order, from 1
+	Diamond  bool   `json:"diamond"` // Whether it's inherited through more than one path
+}
+
+// GraphEdge points from a downstream to an upstream it inherits
+type GraphEdge struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// Graph returns the inheritance graph of the loaded upstreams. Init must be
+// called first so the file counts and flattened order are known.
+func (cr *CommonRepo) Graph() (graph Graph, err error) {
+	if len(cr.flattened) == 0 {
+		return graph, errors.New("upstreams not initialized")
+	}
+
+	ids := make(map[*CommonRepo]int)
+	edges := make(map[GraphEdge]bool)
+	parents := make(map[int]map[int]bool)
+	graph.Edges = []GraphEdge{}
+
+	// Walk the tree depth first so the IDs read top down
+	var walk func(node *CommonRepo) int
+	walk = func(node *CommonRepo) int {
+		if id, ok := ids[node]; ok {
+			return id
+		}
+		id := len(graph.Nodes)
+		ids[node] = id
+		graph.Nodes = append(graph.Nodes, GraphNode{
+			ID:       id,
+			URL:      node.repo.URL,
+			Ref:      node.repo.Ref,
+			Resolved: node.repo.Resolved().String(),
+			Commit:   node.repo.Commit().String(),
+			Config:   node.from,
+			Files:    len(node.repo.Files()),
+			Targets:  len(node.repo.Targets()),
+		})
+		for _, upstream := range node.upstreams {
+			if upstream == nil {
+				continue
+			}
+			edge := GraphEdge{id, walk(upstream)}
+			if !edges[edge] {
+				edges[edge] = true
+				graph.Edges = append(graph.Edges, edge)
+			}
+			if parents[edge.To] == nil {
+				parents[edge.To] = make(map[int]bool)
+			}
+			parents[edge.To][id] = true
+		}
+		return id
+	}
+	walk(cr)
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+
+	graph.Order = make([]int, 0, len(cr.flattened))
+	for i, each := range cr.flattened {
+		id := ids[each]
+		graph.Order = append(graph.Order, id)
+		graph.Nodes[id].Order = i + 1
+	}
+	for id := range graph.Nodes {
+		graph.Nodes[id].Diamond = len(parents[id]) > 1
+	}
+	return
+}
+
+// Write writes the graph in the given format, either dot, mermaid or json
+func (graph Graph) Write(w io.Writer, format string) (err error) {
+	switch format {
+	case "dot":
+		return graph.WriteDOT(w)
+	case "mermaid":
+		return graph.WriteMermaid(w)
+	case "json":
+		return graph.WriteJSON(w)
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+// WriteDOT writes the graph in Graphviz DOT format
+func (graph Graph) WriteDOT(w io.Writer) (err error) {
+	var out strings.Builder
+	out.WriteString("digraph commonrepo {\n")
+	out.WriteString("  rankdir=BT;\n")
+	out.WriteString("  node [shape=box];\n")
+	for _, node := range graph.Nodes {
+		label := strings.ReplaceAll(dotEscape(node.label()), "\n", `\n`)
+		attrs := fmt.Sprintf("label=\"%s\"", label)
+		if node.Diamond {
+			attrs += ", style=filled, fillcolor=\"#fff3b0\""
+		}
+		fmt.Fprintf(&out, "  n%d [%s];\n", node.ID, attrs)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&out, "  n%d -> n%d;\n", edge.From, edge.To)
+	}
+	out.WriteString("}\n")
+	_, err = io.WriteString(w, out.String())
+	return
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart
+func (graph Graph) WriteMermaid(w io.Writer) (err error) {
+	var out strings.Builder
+	out.WriteString("graph BT\n")
+	var diamonds []string
+	for _, node := range graph.Nodes {
+		label := strings.ReplaceAll(mermaidEscape(node.label()), "\n", "<br/>")
+		fmt.Fprintf(&out, "  n%d[\"%s\"]\n", node.ID, label)
+		if node.Diamond {
+			diamonds = append(diamonds, fmt.Sprintf("n%d", node.ID))
+		}
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&out, "  n%d --> n%d\n", edge.From, edge.To)
+	}
+	if len(diamonds) > 0 {
+		out.WriteString("  classDef diamond fill:#fff3b0,stroke:#e0a800\n")
+		fmt.Fprintf(&out, "  class %s diamond\n", strings.Join(diamonds, ","))
+	}
+	_, err = io.WriteString(w, out.String())
+	return
+}
+
+// WriteJSON writes the graph as indented JSON
+func (graph Graph) WriteJSON(w io.Writer) (err error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(graph)
+}
+
+// label returns the multiline description of the node
+func (node GraphNode) label() string {
+	return fmt.Sprintf("%s\n%s -> %s\n%d of %d files\norder %d",
+		node.URL, node.Ref, node.Resolved, node.Targets, node.Files, node.Order)
+}
+
+// dotEscape escapes a string for a quoted DOT attribute
+func dotEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text)
+}
+
+// mermaidEscape escapes a string for a quoted Mermaid label
+func mermaidEscape(text string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(text)
+}
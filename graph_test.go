@@ -0,0 +1,106 @@
+package commonrepo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestGraph(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Graph", func() {
+		var graph Graph
+
+		g.Before(func() {
+			cr, err := NewFrom("testdata/fixtures/graph/downstream.yml", ".")
+			if err != nil {
+				g.FailNow()
+			}
+			if err = cr.Init(); err != nil {
+				g.FailNow()
+			}
+			if graph, err = cr.Graph(); err != nil {
+				g.FailNow()
+			}
+		})
+
+		g.It("requires Init", func() {
+			cr, err := NewFrom("testdata/fixtures/graph/downstream.yml", ".")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = cr.Graph()
+			Expect(err).To(MatchError("upstreams not initialized"))
+		})
+
+		g.It("shares a node between paths to the same upstream", func() {
+			configs := []string{}
+			for _, node := range graph.Nodes {
+				configs = append(configs, node.Config)
+			}
+			Expect(configs).To(Equal([]string{
+				"testdata/fixtures/graph/downstream.yml",
+				"testdata/fixtures/graph/left.yml",
+				"testdata/fixtures/graph/base.yml",
+				"testdata/fixtures/graph/right.yml",
+			}))
+			Expect(graph.Edges).To(Equal([]GraphEdge{{0, 1}, {0, 3}, {1, 2}, {3, 2}}))
+		})
+
+		g.It("marks diamond inheritance", func() {
+			Expect(graph.Nodes[2].Diamond).To(BeTrue())
+			Expect(graph.Nodes[0].Diamond).To(BeFalse())
+			Expect(graph.Nodes[1].Diamond).To(BeFalse())
+		})
+
+		g.It("records the flattened order", func() {
+			Expect(graph.Order).To(Equal([]int{2, 1, 3, 0}))
+			Expect(graph.Nodes[2].Order).To(Equal(1))
+			Expect(graph.Nodes[0].Order).To(Equal(4))
+		})
+
+		g.It("counts files and targets", func() {
+			node := graph.Nodes[2]
+			Expect(node.Targets).To(Equal(2))
+			Expect(node.Files).To(BeNumerically(">", node.Targets))
+			Expect(node.Ref).ToNot(BeEmpty())
+			Expect(node.Resolved).To(HavePrefix("refs/"))
+			Expect(node.Commit).To(HaveLen(40))
+		})
+
+		g.It("writes DOT", func() {
+			var out bytes.Buffer
+			Expect(graph.Write(&out, "dot")).To(Succeed())
+			Expect(out.String()).To(HavePrefix("digraph commonrepo {\n"))
+			Expect(out.String()).To(ContainSubstring("  n0 -> n1;\n"))
+			Expect(out.String()).To(MatchRegexp(`n2 \[label="\.\\n\S+ -> refs/\S+\\n2 of \d+ files\\norder 1", style=filled`))
+		})
+
+		g.It("writes Mermaid", func() {
+			var out bytes.Buffer
+			Expect(graph.Write(&out, "mermaid")).To(Succeed())
+			Expect(out.String()).To(HavePrefix("graph BT\n"))
+			Expect(out.String()).To(ContainSubstring("  n3 --> n2\n"))
+			Expect(out.String()).To(ContainSubstring("<br/>order 1\"]\n"))
+			Expect(out.String()).To(HaveSuffix("  class n2 diamond\n"))
+		})
+
+		g.It("writes JSON", func() {
+			var out bytes.Buffer
+			Expect(graph.Write(&out, "json")).To(Succeed())
+			var decoded Graph
+			Expect(json.Unmarshal(out.Bytes(), &decoded)).To(Succeed())
+			Expect(decoded).To(Equal(graph))
+		})
+
+		g.It("rejects unknown formats", func() {
+			err := graph.Write(&bytes.Buffer{}, "svg")
+			Expect(errors.Is(err, ErrUnknownFormat)).To(BeTrue())
+		})
+	})
+}
@@ -2,7 +2,14 @@
 package testutil
 
 import (
+	"os"
+	"path/filepath"
+	"testing"
+
 	"github.com/MakeNowJust/heredoc/v2"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	. "github.com/onsi/gomega"
 	"github.com/shakefu/commonrepo/pkg/gitutil"
 	"github.com/shakefu/commonrepo/pkg/repos"
@@ -13,6 +20,19 @@ func InlineYaml(doc string) []byte {
 	return []byte(heredoc.Doc(doc))
 }
 
+// Main runs the package's tests with COMMON_CACHE_DIR set to a temporary
+// directory, so they never write to the user's cache, and removes it after.
+// Call it from TestMain.
+func Main(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "commonrepo-cache-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("COMMON_CACHE_DIR", dir)
+	return m.Run()
+}
+
 // LocalRepo returns the local repo or raises an error
 func LocalRepo() (repo *repos.Repo) {
 	// This is a reimplementation of GetLocalRepo for testing purposes
@@ -24,3 +44,101 @@ func LocalRepo() (repo *repos.Repo) {
 	Expect(repo).ShouldNot(BeNil())
 	return
 }
+
+// TaggedRepo creates a repository in a temporary directory with a commit for
+// each tag, which writes the tag to a VERSION file, and returns the directory
+// and the tagged commits. Remove the directory when done.
+func TaggedRepo(tags ...string) (dir string, commits map[string]plumbing.Hash) {
+	dir, err := os.MkdirTemp("", "commonrepo-tagged-")
+	Expect(err).ShouldNot(HaveOccurred())
+	_, err = git.PlainInit(dir, false)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	commits = make(map[string]plumbing.Hash, len(tags))
+	for _, tag := range tags {
+		Expect(os.WriteFile(filepath.Join(dir, "VERSION"), []byte(tag), 0644)).To(Succeed())
+		commits[tag] = CommitFiles(dir, tag, "VERSION")
+		TagCommit(dir, tag, commits[tag])
+	}
+	return
+}
+
+// Fixture is a tagged upstream and a downstream repository to inherit it, with
+// a cache of their own, in temporary directories
+type Fixture struct {
+	Upstream   string                   // Repository made by TaggedRepo
+	Downstream string                   // Empty repository
+	Tags       map[string]plumbing.Hash // Commits of the upstream's tags
+	Cache      string                   // COMMON_CACHE_DIR until Remove
+	previous   string
+	restore    bool // Whether COMMON_CACHE_DIR was set before
+}
+
+// NewFixture creates the repositories, tagging the upstream like TaggedRepo,
+// and points COMMON_CACHE_DIR at the fixture's cache. Remove it when done.
+func NewFixture(tags ...string) (fixture *Fixture) {
+	fixture = &Fixture{}
+	fixture.previous, fixture.restore = os.LookupEnv("COMMON_CACHE_DIR")
+	fixture.Upstream, fixture.Tags = TaggedRepo(tags...)
+
+	var err error
+	fixture.Downstream, err = os.MkdirTemp("", "commonrepo-downstream-")
+	Expect(err).ShouldNot(HaveOccurred())
+	_, err = git.PlainInit(fixture.Downstream, false)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	fixture.Cache, err = os.MkdirTemp("", "commonrepo-cache-")
+	Expect(err).ShouldNot(HaveOccurred())
+	os.Setenv("COMMON_CACHE_DIR", fixture.Cache)
+	return
+}
+
+// Remove deletes the fixture's directories and restores COMMON_CACHE_DIR
+func (fixture *Fixture) Remove() {
+	if fixture.restore {
+		os.Setenv("COMMON_CACHE_DIR", fixture.previous)
+	} else {
+		os.Unsetenv("COMMON_CACHE_DIR")
+	}
+	os.RemoveAll(fixture.Upstream)
+	os.RemoveAll(fixture.Downstream)
+	os.RemoveAll(fixture.Cache)
+}
+
+// WriteFiles writes the files, making their directories, and commits them to
+// the repository at dir with the message
+func WriteFiles(dir string, message string, files map[string]string) plumbing.Hash {
+	names := make([]string, 0, len(files))
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		names = append(names, name)
+	}
+	return CommitFiles(dir, message, names...)
+}
+
+// CommitFiles commits the named files in the repository at dir with the message
+func CommitFiles(dir string, message string, names ...string) plumbing.Hash {
+	repo, err := git.PlainOpen(dir)
+	Expect(err).ShouldNot(HaveOccurred())
+	tree, err := repo.Worktree()
+	Expect(err).ShouldNot(HaveOccurred())
+	for _, name := range names {
+		_, err = tree.Add(name)
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+	hash, err := tree.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com"},
+	})
+	Expect(err).ShouldNot(HaveOccurred())
+	return hash
+}
+
+// TagCommit creates a lightweight tag for the commit in the repository at dir
+func TagCommit(dir string, tag string, hash plumbing.Hash) {
+	repo, err := git.PlainOpen(dir)
+	Expect(err).ShouldNot(HaveOccurred())
+	_, err = repo.CreateTag(tag, hash, nil)
+	Expect(err).ShouldNot(HaveOccurred())
+}
@@ -1,6 +1,8 @@
 package testutil_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/gomega"
@@ -18,5 +20,33 @@ func TestTestutil(t *testing.T) {
 				Expect(repo).ShouldNot(BeNil())
 			})
 		})
+
+		g.Describe("TaggedRepo", func() {
+			g.It("tags a commit for each version", func() {
+				dir, commits := TaggedRepo("v1.0.0", "v1.1.0")
+				defer os.RemoveAll(dir)
+				Expect(commits).To(HaveLen(2))
+				Expect(commits["v1.0.0"]).ToNot(Equal(commits["v1.1.0"]))
+				Expect(os.ReadFile(filepath.Join(dir, "VERSION"))).To(BeEquivalentTo("v1.1.0"))
+			})
+		})
+
+		g.Describe("Fixture", func() {
+			g.It("makes repositories with their own cache", func() {
+				previous := os.Getenv("COMMON_CACHE_DIR")
+				fixture := NewFixture("v1.0.0")
+				Expect(fixture.Tags).To(HaveKey("v1.0.0"))
+				Expect(filepath.Join(fixture.Downstream, ".git")).To(BeADirectory())
+				Expect(os.Getenv("COMMON_CACHE_DIR")).To(Equal(fixture.Cache))
+
+				WriteFiles(fixture.Downstream, "Add config", map[string]string{"a/b.yml": "b: 1\n"})
+				Expect(os.ReadFile(filepath.Join(fixture.Downstream, "a/b.yml"))).To(BeEquivalentTo("b: 1\n"))
+
+				fixture.Remove()
+				Expect(fixture.Downstream).ToNot(BeADirectory())
+				Expect(fixture.Cache).ToNot(BeADirectory())
+				Expect(os.Getenv("COMMON_CACHE_DIR")).To(Equal(previous))
+			})
+		})
 	})
 }
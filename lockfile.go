@@ -0,0 +1,38 @@
+package commonrepo
+
+import (
+	"errors"
+
+	"github.com/shakefu/commonrepo/pkg/lock"
+)
+
+// Lock returns the resolved state of every upstream, in flattened order, with
+// the content hash of each target it contributes.
+//
+// This must be called after Init.
+func (cr *CommonRepo) Lock() (locked *lock.Lock, err error) {
+	if len(cr.flattened) == 0 {
+		return nil, errors.New("upstreams not initialized")
+	}
+
+	locked = &lock.Lock{Upstream: []lock.Upstream{}}
+	// The last flattened entry is ourselves, which isn't an upstream
+	for _, each := range cr.flattened[:len(cr.flattened)-1] {
+		upstream := lock.Upstream{
+			URL:      each.repo.URL,
+			Ref:      each.repo.Ref,
+			Resolved: each.repo.Resolved().String(),
+			Commit:   each.repo.Commit().String(),
+			Files:    make(map[string]string),
+		}
+		for name, target := range each.repo.Targets() {
+			var content []byte
+			if content, err = target.Bytes(); err != nil {
+				return nil, err
+			}
+			upstream.Files[name] = lock.Hash(content)
+		}
+		locked.Upstream = append(locked.Upstream, upstream)
+	}
+	return
+}
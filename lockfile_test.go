@@ -0,0 +1,53 @@
+package commonrepo
+
+import (
+	"testing"
+
+	"github.com/shakefu/commonrepo/pkg/lock"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestLockfile(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Lock", func() {
+		g.It("requires Init", func() {
+			cr, err := NewFrom("testdata/fixtures/local/single.yml", ".")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = cr.Lock()
+			Expect(err).To(MatchError("upstreams not initialized"))
+		})
+
+		g.It("records every upstream but ourselves", func() {
+			cr, err := NewFrom("testdata/fixtures/local/single.yml", ".")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cr.Init()).To(Succeed())
+			locked, err := cr.Lock()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locked.Upstream).To(HaveLen(1))
+			upstream := locked.Upstream[0]
+			Expect(upstream.URL).To(Equal("."))
+			Expect(upstream.Resolved).To(HavePrefix("refs/heads/"))
+			Expect(upstream.Commit).To(HaveLen(40))
+			Expect(upstream.Files).To(HaveKey("testdata/out/empty.yml"))
+			Expect(upstream.Files["testdata/out/empty.yml"]).To(HavePrefix("sha256:"))
+		})
+
+		g.It("verifies against itself", func() {
+			cr, err := NewFrom("testdata/fixtures/local/single.yml", ".")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cr.Init()).To(Succeed())
+			first, err := cr.Lock()
+			Expect(err).ToNot(HaveOccurred())
+			second, err := cr.Lock()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(first.Verify(second)).To(Succeed())
+			second.Upstream[0].Commit = "0000000"
+			Expect(first.Verify(second)).To(MatchError(lock.ErrMismatch))
+		})
+	})
+}
@@ -0,0 +1,125 @@
+package commonrepo
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+	"github.com/shakefu/commonrepo/pkg/lock"
+	"go.uber.org/multierr"
+)
+
+// Prune deletes the files in the repository root which were written by a
+// previous run but are no longer provided by any upstream
+func (composite Composited) Prune() (pruned []string, err error) {
+	var base string
+	if base, err = gitutil.FindLocalRepoPath(); err != nil {
+		return
+	}
+	return composite.PruneFS(osfs.New(base), "/")
+}
+
+// PruneFS deletes the stale files from the given filesystem and removes them
+// from the manifest, returning the names of the deleted files
+func (composite Composited) PruneFS(fs billy.Filesystem, basePaths ...string) (pruned []string, err error) {
+	var base string
+	if base, err = composite.basePath(basePaths); err != nil {
+		return
+	}
+
+	var manifest *lock.Manifest
+	if manifest, err = readManifest(fs, base); err != nil {
+		return
+	}
+
+	// Everything in the manifest that isn't in the composite is stale, whether
+	// or not it still exists locally
+	var errs error
+	for _, name := range manifest.Names() {
+		if _, ok := composite[name]; ok {
+			continue
+		}
+		var removed bool
+		if removed, err = pruneFile(fs, base, name, manifest.Files[name]); err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		// Kept or not, it isn't ours to manage anymore
+		delete(manifest.Files, name)
+		if removed {
+			pruned = append(pruned, name)
+		}
+	}
+
+	// Save whatever we managed to prune, even if some of it failed
+	if err = manifest.Write(fs, manifestPath(base)); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	return pruned, errs
+}
+
+// pruneFile deletes a stale file, or just its managed blocks if that's all we
+// wrote. A file the downstream changed since we wrote it is theirs now, so it's
+// kept and removed is false.
+func pruneFile(fs billy.Filesystem, base string, name string, file lock.ManagedFile) (removed bool, err error) {
+	fullName := filepath.Clean(filepath.Join(base, name))
+	content, err := util.ReadFile(fs, fullName)
+	switch {
+	case os.IsNotExist(err):
+		return true, nil
+	case err != nil:
+		return
+	case customized(file, content):
+		return false, nil
+	case file.Block:
+		var stripped []byte
+		if stripped, err = unblock(name, content); err != nil {
+			return
+		}
+		// Keep whatever the downstream has of its own
+		if stripped != nil {
+			return true, util.WriteFile(fs, fullName, stripped, 0644)
+		}
+	}
+
+	if err = fs.Remove(fullName); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	removeEmptyDirs(fs, base, filepath.Dir(fullName))
+	return true, nil
+}
+
+// customized returns true if the downstream changed a whole file since we last
+// wrote it. Files where we only manage blocks are expected to change.
+func customized(file lock.ManagedFile, content []byte) bool {
+	return !file.Block && file.Hash != lock.Hash(content)
+}
+
+// removeEmptyDirs removes dir and its parents, stopping at base or the first
+// directory which isn't empty
+func removeEmptyDirs(fs billy.Filesystem, base string, dir string) {
+	base = filepath.Clean(base)
+	for dir = filepath.Clean(dir); dir != base && dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		entries, err := fs.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		if err = fs.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// manifestPath returns the path to the manifest in the base path
+func manifestPath(base string) string {
+	return filepath.Join(base, lock.ManifestFileName)
+}
+
+// readManifest returns the manifest from the base path, or an empty manifest if
+// we haven't written one yet
+func readManifest(fs billy.Filesystem, base string) (*lock.Manifest, error) {
+	return lock.ReadManifest(fs, manifestPath(base))
+}
@@ -0,0 +1,110 @@
+package commonrepo
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/shakefu/commonrepo/pkg/files"
+	"github.com/shakefu/commonrepo/pkg/lock"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestManifest(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Manifest", func() {
+		var composite Composited
+		var smaller Composited
+		var fs billy.Filesystem
+
+		g.Before(func() {
+			cr, err := NewFrom("testdata/fixtures/local/single.yml", ".")
+			if err != nil {
+				g.FailNow()
+			}
+			if err = cr.Init(); err != nil {
+				g.FailNow()
+			}
+			composite = cr.Composite()
+			smaller = Composited{}
+			for name, target := range composite {
+				smaller[name] = target
+			}
+			delete(smaller, "testdata/out/single.yml")
+		})
+
+		g.BeforeEach(func() {
+			fs = memfs.New()
+		})
+
+		g.It("records what was written", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			manifest, err := lock.ReadManifest(fs, lock.ManifestFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Names()).To(Equal([]string{
+				"testdata/out/.not_commonrepo.yml",
+				"testdata/out/empty.yml",
+				"testdata/out/single.yml",
+			}))
+			target := composite["testdata/out/single.yml"]
+			content, err := target.Bytes()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Files["testdata/out/single.yml"].Hash).To(Equal(lock.Hash(content)))
+		})
+
+		g.It("keeps stale files until they're pruned", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			Expect(smaller.WriteFS(fs, "/")).To(Succeed())
+			manifest, err := lock.ReadManifest(fs, lock.ManifestFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Files).To(HaveKey("testdata/out/single.yml"))
+			Expect(files.List(fs)).To(ContainElement("testdata/out/single.yml"))
+		})
+
+		g.It("prunes stale files", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			pruned, err := smaller.PruneFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pruned).To(Equal([]string{"testdata/out/single.yml"}))
+			Expect(files.List(fs)).To(Equal([]string{
+				".commonrepo.manifest",
+				"testdata/out/.not_commonrepo.yml",
+				"testdata/out/empty.yml",
+			}))
+			manifest, err := lock.ReadManifest(fs, lock.ManifestFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Files).ToNot(HaveKey("testdata/out/single.yml"))
+		})
+
+		g.It("leaves stale files the downstream changed", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			Expect(util.WriteFile(fs, "testdata/out/single.yml", []byte("ours: true\n"), 0644)).To(Succeed())
+			plan, err := smaller.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.Count(Stale)).To(Equal(0))
+
+			pruned, err := smaller.PruneFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pruned).To(BeEmpty())
+			Expect(util.ReadFile(fs, "testdata/out/single.yml")).To(BeEquivalentTo("ours: true\n"))
+			manifest, err := lock.ReadManifest(fs, lock.ManifestFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Files).ToNot(HaveKey("testdata/out/single.yml"))
+		})
+
+		g.It("removes directories left empty", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			pruned, err := Composited{}.PruneFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pruned).To(HaveLen(3))
+			_, err = fs.Stat("testdata")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+}
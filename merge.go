@@ -0,0 +1,120 @@
+package commonrepo
+
+import (
+	"bytes"
+
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/merge"
+	"github.com/shakefu/commonrepo/pkg/repos"
+)
+
+// mergeRules returns the merge rules from every upstream in inheritance order,
+// so the downstream rules are last
+func (cr *CommonRepo) mergeRules() (rules []config.MergeRule) {
+	for _, each := range cr.flattened {
+		rules = append(rules, each.config.Merge...)
+	}
+	return
+}
+
+// findMergeRule returns the last of the rules matching the target name, or nil
+// if it shouldn't be merged
+func findMergeRule(rules []config.MergeRule, name string) *config.MergeRule {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].Match(name) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// layer stacks the target on top of the one it's replacing in the composite,
+// if there is one
+func layer(target repos.Target, below *repos.Target, rule *config.MergeRule) repos.Target {
+	target.Merge = rule
+	if below == nil {
+		return target
+	}
+	// Copy so we never share the backing array with another target
+	target.Layers = make([]repos.Target, 0, len(below.Layers)+1)
+	target.Layers = append(target.Layers, below.Layers...)
+	flat := *below
+	flat.Layers = nil
+	// The same upstream inherited again with other rules isn't another layer
+	if !sameSource(target, flat) {
+		target.Layers = append(target.Layers, flat)
+	}
+	return target
+}
+
+// render returns the content to write for the named target, merging it with
+// its layers and the existing local content when it has a merge rule
+func render(name string, target repos.Target, existing []byte) (content []byte, err error) {
+	if target.Merge == nil {
+		return target.Bytes()
+	}
+	if isBlock(name, target) {
+		return renderBlocks(name, target, existing)
+	}
+
+	layers := make([][]byte, 0, len(target.Layers)+2)
+	if existing != nil && merge.IncludesLocal(*target.Merge, name) {
+		layers = append(layers, existing)
+	}
+	for _, each := range stack(target) {
+		if content, err = each.Bytes(); err != nil {
+			return
+		}
+		layers = append(layers, content)
+	}
+
+	// Don't reformat a file that has nothing to merge with
+	if len(layers) == 1 {
+		return content, nil
+	}
+	return merge.Merge(*target.Merge, name, layers...)
+}
+
+// renderBlocks writes the content of every layer between its upstream's
+// markers in the existing local content
+func renderBlocks(name string, target repos.Target, existing []byte) (content []byte, err error) {
+	layers := stack(target)
+	blocks := make([]merge.Block, 0, len(layers))
+	for _, each := range layers {
+		block := merge.Block{Upstream: each.Repo().URL}
+		if block.Content, err = each.Bytes(); err != nil {
+			return
+		}
+		blocks = append(blocks, block)
+	}
+	return merge.Blocks(name, existing, blocks...)
+}
+
+// isBlock returns true if the named target only manages blocks within the
+// local file
+func isBlock(name string, target repos.Target) bool {
+	if target.Merge == nil {
+		return false
+	}
+	strategy, err := merge.StrategyFor(*target.Merge, name)
+	return err == nil && strategy == config.MergeBlock
+}
+
+// stack returns the layers of the target with the target itself on top
+func stack(target repos.Target) []repos.Target {
+	layers := make([]repos.Target, 0, len(target.Layers)+1)
+	layers = append(layers, target.Layers...)
+	return append(layers, target)
+}
+
+// unblock returns the content with the managed blocks removed, or nil if
+// there's nothing left and the file should be deleted
+func unblock(name string, content []byte) (stripped []byte, err error) {
+	if stripped, err = merge.StripBlocks(name, content); err != nil {
+		return
+	}
+	if len(bytes.TrimSpace(stripped)) == 0 {
+		return nil, nil
+	}
+	return
+}
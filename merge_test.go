@@ -0,0 +1,180 @@
+package commonrepo
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	. "github.com/shakefu/commonrepo/internal/testutil"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestMergeComposite(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Merge", func() {
+		var composite Composited
+		var fs billy.Filesystem
+
+		g.Before(func() {
+			cr, err := NewFrom("testdata/fixtures/merge/downstream.yml", ".")
+			if err != nil {
+				g.FailNow()
+			}
+			if err = cr.Init(); err != nil {
+				g.FailNow()
+			}
+			composite = cr.Composite()
+		})
+
+		g.BeforeEach(func() {
+			fs = memfs.New()
+		})
+
+		g.It("keeps the replaced targets as layers", func() {
+			target := composite["merged.yml"]
+			Expect(target.Merge).ToNot(BeNil())
+			Expect(target.Name).To(Equal("testdata/fixtures/merge/local.yml"))
+			Expect(target.Layers).To(HaveLen(1))
+			Expect(target.Layers[0].Name).To(Equal("testdata/fixtures/merge/shared.yml"))
+		})
+
+		g.It("merges the upstreams", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			data, err := util.ReadFile(fs, "merged.yml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal(string(InlineYaml(`
+			run:
+			  timeout: 5m
+			linters:
+			  enable:
+			    - errcheck
+			    - govet
+			    - revive
+			issues:
+			  max-same: 3
+			`))))
+		})
+
+		g.It("merges the local file", func() {
+			Expect(util.WriteFile(fs, "merged.yml", InlineYaml(`
+			mine: true
+			run:
+			  timeout: 1m
+			linters:
+			  enable:
+			    - gosec`), 0644)).To(Succeed())
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			data, err := util.ReadFile(fs, "merged.yml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal(string(InlineYaml(`
+			mine: true
+			run:
+			  timeout: 5m
+			linters:
+			  enable:
+			    - gosec
+			    - errcheck
+			    - govet
+			    - revive
+			issues:
+			  max-same: 3
+			`))))
+		})
+
+		g.It("is stable once merged", func() {
+			Expect(util.WriteFile(fs, "merged.yml", []byte("mine: true\n"), 0644)).To(Succeed())
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.InSync()).To(BeTrue())
+		})
+	})
+
+	g.Describe("Blocks", func() {
+		var composite Composited
+		var fs billy.Filesystem
+		block := "# BEGIN commonrepo:.\nlint:\n\tgolangci-lint run\n# END commonrepo:.\n"
+
+		g.Before(func() {
+			cr, err := NewFrom("testdata/fixtures/block/downstream.yml", ".")
+			if err != nil {
+				g.FailNow()
+			}
+			if err = cr.Init(); err != nil {
+				g.FailNow()
+			}
+			composite = cr.Composite()
+		})
+
+		g.BeforeEach(func() {
+			fs = memfs.New()
+		})
+
+		g.It("writes a new file of blocks", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			data, err := util.ReadFile(fs, "Makefile")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal(block))
+		})
+
+		g.It("leaves the rest of the local file alone", func() {
+			Expect(util.WriteFile(fs, "Makefile", []byte("build:\n\tgo build\n"), 0644)).To(Succeed())
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			data, err := util.ReadFile(fs, "Makefile")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("build:\n\tgo build\n\n" + block))
+
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.InSync()).To(BeTrue())
+		})
+
+		g.It("removes blocks from upstreams which don't contribute anymore", func() {
+			gone := "# BEGIN commonrepo:github.com/a/gone\ngone:\n# END commonrepo:github.com/a/gone\n"
+			Expect(util.WriteFile(fs, "Makefile", []byte("build:\n\tgo build\n\n"+gone), 0644)).To(Succeed())
+
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan).To(HaveLen(1))
+			Expect(plan[0].Action).To(Equal(Update))
+			Expect(string(plan[0].Content)).To(Equal("build:\n\tgo build\n\n" + block))
+
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			plan, err = composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.InSync()).To(BeTrue())
+		})
+
+		g.It("prunes only the blocks", func() {
+			Expect(util.WriteFile(fs, "Makefile", []byte("build:\n\tgo build\n"), 0644)).To(Succeed())
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+
+			plan, err := Composited{}.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan).To(HaveLen(1))
+			Expect(plan[0].Action).To(Equal(Stale))
+			Expect(string(plan[0].Content)).To(Equal("build:\n\tgo build\n"))
+
+			pruned, err := Composited{}.PruneFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pruned).To(Equal([]string{"Makefile"}))
+			data, err := util.ReadFile(fs, "Makefile")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("build:\n\tgo build\n"))
+		})
+
+		g.It("prunes files that were only blocks", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			_, err := Composited{}.PruneFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = fs.Stat("Makefile")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+}
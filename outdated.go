@@ -0,0 +1,194 @@
+package commonrepo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+)
+
+// ErrNoUpdate is returned when updating upstreams which have nothing newer
+var ErrNoUpdate = errors.New("no upstream to update")
+
+// Outdated is an upstream from the config and the newest version tag it has
+type Outdated struct {
+	Index    int    `json:"index"`    // Position in the config's upstreams
+	Name     string `json:"name"`     // Last element of the URL
+	URL      string `json:"url"`      // URL in the config
+	Ref      string `json:"ref"`      // Ref in the config
+	Current  string `json:"current"`  // Version tag Ref is or resolves to, if any
+	Latest   string `json:"latest"`   // Newest version tag, if any
+	Outdated bool   `json:"outdated"` // Whether Ref doesn't get Latest
+	Major    bool   `json:"major"`    // Whether Latest is a new major version
+}
+
+// OutdatedList is every upstream in a config, in order
+type OutdatedList []Outdated
+
+// ListOutdated asks every upstream in the config for its version tags and
+// compares the newest to the upstream's ref. Branches and commits are never
+// outdated, since there's no version to compare.
+func ListOutdated(cfg *config.Config) (list OutdatedList, err error) {
+	list = OutdatedList{}
+	for i, upstream := range cfg.Upstream {
+		var outdated Outdated
+		if outdated, err = checkOutdated(upstream); err != nil {
+			return nil, err
+		}
+		outdated.Index = i
+		list = append(list, outdated)
+	}
+	return
+}
+
+// checkOutdated compares the newest version tag of the upstream to its ref
+func checkOutdated(upstream config.Upstream) (outdated Outdated, err error) {
+	outdated = Outdated{
+		Name: path.Base(gitutil.NormalizeURL(upstream.URL)),
+		URL:  upstream.URL,
+		Ref:  upstream.Ref,
+	}
+
+	url, err := gitutil.ApplyInsteadOf(upstream.URL)
+	if err != nil {
+		return
+	}
+	refs, err := gitutil.GetRefs(url)
+	if err != nil {
+		return
+	}
+	all, _ := semver.NewConstraint("*")
+	latest, err := gitutil.MatchVersion(refs, all, upstream.Prerelease)
+	if err != nil {
+		// Upstreams without version tags can't be outdated
+		return outdated, nil
+	}
+	outdated.Latest = latest.Short()
+
+	// The current version is the tag the ref is, or what its range gets
+	current := plumbing.NewTagReferenceName(upstream.Ref)
+	if upstream.Version != nil {
+		if current, err = gitutil.MatchVersion(refs, upstream.Version, upstream.Prerelease); err != nil {
+			// Nothing matches the range anymore, so anything is newer
+			outdated.Outdated = true
+			return outdated, nil
+		}
+	}
+	if _, ok := refs[current]; !ok {
+		return
+	}
+	version, err := semver.NewVersion(current.Short())
+	if err != nil {
+		return outdated, nil
+	}
+	outdated.Current = current.Short()
+
+	newest, err := semver.NewVersion(outdated.Latest)
+	if err != nil {
+		return
+	}
+	outdated.Outdated = newest.GreaterThan(version)
+	outdated.Major = newest.Major() > version.Major()
+	return
+}
+
+// Matches returns true if name is the upstream's name or URL
+func (outdated Outdated) Matches(name string) bool {
+	return name == outdated.Name || gitutil.NormalizeURL(name) == gitutil.NormalizeURL(outdated.URL)
+}
+
+// Update returns the ref which gets the latest version. Caret and tilde ranges
+// keep their operator, anything else is replaced by the latest tag.
+func (outdated Outdated) Update() string {
+	if strings.HasPrefix(outdated.Ref, "^") || strings.HasPrefix(outdated.Ref, "~") {
+		if version, err := semver.NewVersion(outdated.Latest); err == nil {
+			return outdated.Ref[:1] + version.String()
+		}
+	}
+	return outdated.Latest
+}
+
+// Write prints a table of the upstreams, marking major version bumps
+func (list OutdatedList) Write(w io.Writer) (err error) {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tREF\tCURRENT\tLATEST")
+	for _, outdated := range list {
+		note := ""
+		switch {
+		case outdated.Major:
+			note = "major"
+		case outdated.Outdated:
+			note = "outdated"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", outdated.Name, orDash(outdated.Ref),
+			orDash(outdated.Current), orDash(outdated.Latest), note)
+	}
+	return table.Flush()
+}
+
+// WriteJSON prints the upstreams as indented JSON
+func (list OutdatedList) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(list)
+}
+
+// orDash returns text, or a dash if it's empty
+func orDash(text string) string {
+	if text == "" {
+		return "-"
+	}
+	return text
+}
+
+// UpdateConfig returns the config data with the refs of the outdated upstreams
+// in the list changed to get their latest version, keeping the config's
+// comments and formatting. Giving a name only updates upstreams matching it,
+// and giving a ref sets them to it instead, whether they're outdated or not. A
+// name which matches no upstream is ErrUnknownUpstream.
+func UpdateConfig(data []byte, list OutdatedList, name string, ref string) (edited []byte, updated OutdatedList, err error) {
+	if ref != "" && name == "" {
+		return nil, nil, errors.New("updating to a ref needs an upstream name")
+	}
+	edited = data
+	matched := false
+	for _, outdated := range list {
+		if name != "" && !outdated.Matches(name) {
+			continue
+		}
+		matched = true
+		to := ref
+		if to == "" {
+			if !outdated.Outdated {
+				continue
+			}
+			to = outdated.Update()
+		}
+		if to == outdated.Ref {
+			continue
+		}
+		if edited, err = config.SetUpstreamRef(edited, outdated.Index, to); err != nil {
+			return nil, nil, err
+		}
+		outdated.Ref = to
+		updated = append(updated, outdated)
+	}
+	if name != "" && !matched {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownUpstream, name)
+	}
+	if len(updated) == 0 {
+		if name != "" {
+			return nil, nil, fmt.Errorf("%w: %s", ErrNoUpdate, name)
+		}
+		return nil, nil, ErrNoUpdate
+	}
+	return
+}
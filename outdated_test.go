@@ -0,0 +1,114 @@
+package commonrepo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/shakefu/commonrepo/internal/testutil"
+	"github.com/shakefu/commonrepo/pkg/config"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestOutdated(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Outdated", func() {
+		var dir string
+		var data []byte
+		var list OutdatedList
+
+		g.Before(func() {
+			dir, _ = TaggedRepo("v1.4.0", "v1.4.2", "v2.0.0")
+
+			data = []byte("upstream:\n" +
+				"  - url: " + dir + " # patches\n" +
+				"    ref: ^1.4\n" +
+				"  - url: " + dir + "\n" +
+				"    ref: v2.0.0\n" +
+				"  - url: " + dir + "\n" +
+				"    ref: master\n")
+			cfg, err := config.ParseConfig(data)
+			if err != nil {
+				g.FailNow()
+			}
+			if list, err = ListOutdated(cfg); err != nil {
+				g.FailNow()
+			}
+		})
+
+		g.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.Describe("ListOutdated", func() {
+			g.It("finds what ranges resolve to and the newest tag", func() {
+				Expect(list).To(HaveLen(3))
+				Expect(list[0].Name).To(Equal(filepath.Base(dir)))
+				Expect(list[0].Current).To(Equal("v1.4.2"))
+				Expect(list[0].Latest).To(Equal("v2.0.0"))
+				Expect(list[0].Outdated).To(BeTrue())
+				Expect(list[0].Major).To(BeTrue())
+			})
+
+			g.It("knows when a tag is the newest", func() {
+				Expect(list[1].Current).To(Equal("v2.0.0"))
+				Expect(list[1].Outdated).To(BeFalse())
+				Expect(list[1].Major).To(BeFalse())
+			})
+
+			g.It("never has branches outdated", func() {
+				Expect(list[2].Current).To(BeEmpty())
+				Expect(list[2].Latest).To(Equal("v2.0.0"))
+				Expect(list[2].Outdated).To(BeFalse())
+			})
+
+			g.It("writes JSON", func() {
+				var buf bytes.Buffer
+				Expect(list.WriteJSON(&buf)).To(Succeed())
+				var decoded []map[string]interface{}
+				Expect(json.Unmarshal(buf.Bytes(), &decoded)).To(Succeed())
+				Expect(decoded[0]).To(HaveKeyWithValue("major", true))
+				Expect(decoded[0]).To(HaveKeyWithValue("latest", "v2.0.0"))
+			})
+		})
+
+		g.Describe("UpdateConfig", func() {
+			g.It("updates outdated refs keeping range operators", func() {
+				edited, updated, err := UpdateConfig(data, list, "", "")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(updated).To(HaveLen(1))
+				Expect(string(edited)).To(Equal(string(bytes.Replace(data,
+					[]byte("ref: ^1.4\n"), []byte("ref: ^2.0.0\n"), 1))))
+			})
+
+			g.It("sets the named upstream to the given ref", func() {
+				edited, updated, err := UpdateConfig(data, list[1:2], filepath.Base(dir), "v1.4.0")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(updated).To(HaveLen(1))
+				Expect(string(edited)).To(Equal(string(bytes.Replace(data,
+					[]byte("ref: v2.0.0\n"), []byte("ref: v1.4.0\n"), 1))))
+			})
+
+			g.It("errors when there's nothing to update", func() {
+				_, _, err := UpdateConfig(data, list[1:], "", "")
+				Expect(errors.Is(err, ErrNoUpdate)).To(BeTrue())
+			})
+
+			g.It("errors for names which match no upstream", func() {
+				_, _, err := UpdateConfig(data, list, "nope", "")
+				Expect(errors.Is(err, ErrUnknownUpstream)).To(BeTrue())
+				Expect(err).To(MatchError(ContainSubstring("nope")))
+				_, _, err = UpdateConfig(data, list, "nope", "v1.4.0")
+				Expect(errors.Is(err, ErrUnknownUpstream)).To(BeTrue())
+			})
+		})
+	})
+}
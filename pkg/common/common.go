@@ -4,12 +4,15 @@ package common
 
 import (
 	"os"
+	"path/filepath"
 	"sort"
 )
 
 // ConfigFileGlob is a glob pattern for locating a repo's config
 var defaults struct {
 	ConfigFileGlob string
+	Offline        bool
+	VendorDir      string
 }
 
 func init() {
@@ -24,6 +27,50 @@ func ConfigFileGlob() string {
 	return defaults.ConfigFileGlob
 }
 
+// CacheDir returns the directory upstream repositories are cached in, from
+// COMMON_CACHE_DIR or the user's cache directory. An empty string means upstreams
+// aren't cached.
+func CacheDir() string {
+	if val, ok := os.LookupEnv("COMMON_CACHE_DIR"); ok {
+		return val
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "commonrepo")
+}
+
+// Offline returns true if remote repositories must not be contacted, from
+// COMMON_OFFLINE or SetOffline.
+func Offline() bool {
+	if val, ok := os.LookupEnv("COMMON_OFFLINE"); ok {
+		return val != "" && val != "0" && val != "false"
+	}
+	return defaults.Offline
+}
+
+// SetOffline sets whether remote repositories must not be contacted, unless
+// COMMON_OFFLINE overrides it.
+func SetOffline(offline bool) {
+	defaults.Offline = offline
+}
+
+// VendorDir returns the directory vendored upstreams are kept in, from
+// COMMON_VENDOR_DIR or SetVendorDir. An empty string means there isn't one.
+func VendorDir() string {
+	if val, ok := os.LookupEnv("COMMON_VENDOR_DIR"); ok {
+		return val
+	}
+	return defaults.VendorDir
+}
+
+// SetVendorDir sets the directory vendored upstreams are kept in, unless
+// COMMON_VENDOR_DIR overrides it.
+func SetVendorDir(dir string) {
+	defaults.VendorDir = dir
+}
+
 // SortedKeys returns the keys of the given map in sorted order
 func SortedKeys(m map[string]string) []string {
 	keys := make([]string, 0, len(m))
@@ -3,6 +3,7 @@ package common_test
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/shakefu/commonrepo/pkg/common"
@@ -58,6 +59,51 @@ func TestCommon(t *testing.T) {
 				os.Unsetenv("COMMON_CONFIG_GLOB")
 			})
 		})
+
+		g.Describe("CacheDir", func() {
+			g.It("defaults to the user cache", func() {
+				cache, err := os.UserCacheDir()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(CacheDir()).To(Equal(filepath.Join(cache, "commonrepo")))
+			})
+
+			g.It("allows for env override", func() {
+				os.Setenv("COMMON_CACHE_DIR", "/tmp/commonrepo")
+				Expect(CacheDir()).To(Equal("/tmp/commonrepo"))
+			})
+
+			g.It("is disabled by an empty env", func() {
+				os.Setenv("COMMON_CACHE_DIR", "")
+				Expect(CacheDir()).To(BeEmpty())
+			})
+
+			g.After(func() {
+				os.Unsetenv("COMMON_CACHE_DIR")
+			})
+		})
+
+		g.Describe("Offline", func() {
+			g.It("defaults to online", func() {
+				Expect(Offline()).To(BeFalse())
+			})
+
+			g.It("can be set", func() {
+				SetOffline(true)
+				Expect(Offline()).To(BeTrue())
+			})
+
+			g.It("allows for env override", func() {
+				os.Setenv("COMMON_OFFLINE", "false")
+				Expect(Offline()).To(BeFalse())
+				os.Setenv("COMMON_OFFLINE", "1")
+				Expect(Offline()).To(BeTrue())
+			})
+
+			g.After(func() {
+				SetOffline(false)
+				os.Unsetenv("COMMON_OFFLINE")
+			})
+		})
 	})
 }
 
@@ -8,6 +8,10 @@ import (
 	"github.com/Masterminds/semver/v3"
 )
 
+// ContextVar is the template variable holding the template context, which can't
+// be set in template-vars
+const ContextVar = "CommonRepo"
+
 // ParseConfig takes yaml data and returns a Config instance
 func ParseConfig(data []byte) (config *Config, err error) {
 	cfg, err := YamlParse(data)
@@ -28,31 +32,60 @@ func ParseConfig(data []byte) (config *Config, err error) {
 		exclude = []string{}
 	}
 
-	var template []string
-	if cfg.Template != nil {
-		template = cfg.Template
-	} else {
-		template = []string{}
-	}
-
 	var templateVars map[string]interface{}
 	if cfg.TemplateVars != nil {
 		templateVars = cfg.TemplateVars
 	} else {
 		templateVars = map[string]interface{}{}
 	}
+	if _, ok := templateVars[ContextVar]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrVarReserved, ContextVar)
+	}
+
+	var partials []string
+	if cfg.Partials != nil {
+		partials = cfg.Partials
+	} else {
+		partials = []string{}
+	}
+
+	var override []string
+	if cfg.Override != nil {
+		override = cfg.Override
+	} else {
+		override = []string{}
+	}
 
 	config = &Config{}
 	config.Include = include
 	config.Exclude = exclude
-	config.Template = template
 	config.TemplateVars = templateVars
+	config.Partials = partials
+	config.Override = override
 	config.InstallFrom = cfg.InstallFrom
 	config.InstallWith = cfg.InstallWith
 
+	if config.Template, config.Delims, err = parseTemplates(cfg.Templates); err != nil {
+		return nil, err
+	}
+	// Files with the template suffix are templates wherever they are
+	var suffixed string
+	if suffixed, err = parseTemplateSuffix(cfg.Suffix); err != nil {
+		return nil, err
+	}
+	if suffixed != "" {
+		config.Template = append(config.Template, suffixed)
+		config.TemplateSuffix = cfg.Suffix
+	}
 	if err = config.copyRename(cfg.Rename); err != nil {
 		return nil, err
 	}
+	if config.Policies, err = parsePolicies(cfg.Policies); err != nil {
+		return nil, err
+	}
+	if config.Merge, err = parseMerges(cfg.Merge); err != nil {
+		return nil, err
+	}
 	if err = config.copyUpstream(cfg.Upstream); err != nil {
 		return nil, err
 	}
@@ -64,23 +97,33 @@ func ParseConfig(data []byte) (config *Config, err error) {
 
 // Config provides the desired configuration for the commonrepo
 type Config struct {
-	Include      []string               // File globs to include
-	Exclude      []string               // File globs to exclude
-	Template     []string               // File globs to treat as templates
-	TemplateVars map[string]interface{} // Map of template variables
-	Install      []Install              // List of tool versions to install
-	InstallFrom  string                 // Path to install from
-	InstallWith  []string               // Priority list of install managers to use
-	Rename       []Rename               // Rename regex rules to apply to files
-	Upstream     []Upstream             // List of upstream CommonRepos
+	Include        []string               // File globs to include
+	Exclude        []string               // File globs to exclude
+	Template       []string               // File globs to treat as templates
+	Delims         []DelimRule            // Action delimiters for template globs
+	TemplateSuffix string                 // Suffix making a file a template, stripped from its destination
+	TemplateVars   map[string]interface{} // Map of template variables
+	Partials       []string               // File globs to parse as partials for every template
+	Install        []Install              // List of tool versions to install
+	InstallFrom    string                 // Path to install from
+	InstallWith    []string               // Priority list of install managers to use
+	Rename         []Rename               // Rename regex rules to apply to files
+	Policies       []PolicyRule           // Write policies for file globs
+	Merge          []MergeRule            // Merge rules for targets from multiple upstreams
+	Override       []string               // Target globs which are expected to shadow another upstream
+	Upstream       []Upstream             // List of upstream CommonRepos
 }
 
 type Upstream struct {
-	URL     string
-	Ref     string
-	Include []string
-	Exclude []string
-	Rename  []Rename
+	URL        string
+	Ref        string
+	Version    *semver.Constraints // Set when Ref is a version range
+	Prerelease bool                // Whether Version matches pre-release tags
+	Policy     Policy              // Write policy for every file from the upstream
+	Include    []string
+	Exclude    []string
+	Rename     []Rename
+	Policies   []PolicyRule
 }
 
 type Install struct {
@@ -97,10 +140,22 @@ func (config *Config) copyRename(renames []map[string]string) (err error) {
 // copyUpstream parses and copies the upstreams into our config
 func (config *Config) copyUpstream(upstreams []yamlUpstream) (err error) {
 	var renames []Rename
+	var policy Policy
+	var policies []PolicyRule
+	var version *semver.Constraints
 	for _, item := range upstreams {
+		if version, err = VersionRange(item.Ref); err != nil {
+			return
+		}
 		if renames, err = parseRenames(item.Rename); err != nil {
 			return
 		}
+		if policy, err = parseUpstreamPolicy(item); err != nil {
+			return
+		}
+		if policies, err = parsePolicies(item.Policies); err != nil {
+			return
+		}
 
 		var includes []string
 		if item.Include != nil {
@@ -117,16 +172,36 @@ func (config *Config) copyUpstream(upstreams []yamlUpstream) (err error) {
 		}
 
 		config.Upstream = append(config.Upstream, Upstream{
-			URL:     item.URL,
-			Ref:     item.Ref,
-			Include: includes,
-			Exclude: excludes,
-			Rename:  renames,
+			URL:        item.URL,
+			Ref:        item.Ref,
+			Version:    version,
+			Prerelease: item.Prerelease,
+			Policy:     policy,
+			Include:    includes,
+			Exclude:    excludes,
+			Rename:     renames,
+			Policies:   policies,
 		})
 	}
 	return
 }
 
+// versionRange matches refs which can only be a version range, since they use
+// characters git doesn't allow in ref names, or wildcards like 1.x
+var versionRange = regexp.MustCompile(`[\s~^*<>=!|,]|^v?\d+(\.\d+)*\.[xX]`)
+
+// VersionRange returns the semver constraints for a ref which is a version
+// range, like ^1.4 or ">=2.0.0 <3", or nil for any other ref.
+func VersionRange(ref string) (constraints *semver.Constraints, err error) {
+	if !versionRange.MatchString(ref) {
+		return
+	}
+	if constraints, err = semver.NewConstraint(ref); err != nil {
+		err = fmt.Errorf("%w: %q: %v", ErrRefInvalid, ref, err)
+	}
+	return
+}
+
 // copyInstall parses and copies the installs into our config
 func (config *Config) copyInstall(installs []map[string]string) (err error) {
 	var constraints *semver.Constraints
@@ -1,6 +1,7 @@
 package config_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/Masterminds/semver/v3"
@@ -98,6 +99,42 @@ func TestConfig(t *testing.T) {
 				Expect(r.Apply("somepath/foo.md")).To(Equal("somepath/docs/foo.md"))
 			})
 
+			g.It("parses version range refs", func() {
+				config, err := config.ParseConfig(InlineYaml(`
+				upstream:
+				  - url: github.com/shakefu/commonrepo
+				    ref: ^1.4
+				    prerelease: true
+				  - url: github.com/shakefu/commonrepo
+				    ref: ">=2.0.0 <3"
+				  - url: github.com/shakefu/commonrepo
+				    ref: v1.4.0`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(config.Upstream).To(HaveLen(3))
+				caret, between, tag := config.Upstream[0], config.Upstream[1], config.Upstream[2]
+				Expect(caret.Version.Check(semver.MustParse("1.9.0"))).To(BeTrue())
+				Expect(caret.Version.Check(semver.MustParse("2.0.0"))).To(BeFalse())
+				Expect(caret.Prerelease).To(BeTrue())
+				Expect(between.Version.Check(semver.MustParse("2.5.1"))).To(BeTrue())
+				Expect(between.Prerelease).To(BeFalse())
+				Expect(tag.Version).To(BeNil())
+			})
+
+			g.It("errors with garbage version ranges", func() {
+				_, err := config.ParseConfig(InlineYaml(`
+				upstream:
+				  - url: github.com/shakefu/commonrepo
+				    ref: ^1.2.3.4`))
+				Expect(errors.Is(err, config.ErrRefInvalid)).To(BeTrue())
+			})
+
+			g.It("errors when template-vars sets the context", func() {
+				_, err := config.ParseConfig(InlineYaml(`
+				template-vars:
+				  CommonRepo: mine`))
+				Expect(errors.Is(err, config.ErrVarReserved)).To(BeTrue())
+			})
+
 			g.It("parses basic upstreams", func() {
 				config, err := config.ParseConfig(InlineYaml(`
 				upstream:
@@ -132,6 +169,156 @@ func TestConfig(t *testing.T) {
 					Equal(map[string]interface{}{"project": "commonrepo"}))
 			})
 
+			g.It("parses template delimiters", func() {
+				cfg, err := config.ParseConfig(InlineYaml(`
+				template:
+				  - "templates/*"
+				  - glob: ".github/workflows/*.yml"
+				    delims: ["[[", "]]"]
+				  - {glob: "charts/**", delims: ["<%", "%>"]}`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfg.Template).To(Equal([]string{
+					"templates/*", ".github/workflows/*.yml", "charts/**"}))
+				Expect(cfg.Delims).To(Equal([]config.DelimRule{
+					{Glob: ".github/workflows/*.yml", Left: "[[", Right: "]]"},
+					{Glob: "charts/**", Left: "<%", Right: "%>"},
+				}))
+			})
+
+			g.It("parses partials", func() {
+				cfg, err := config.ParseConfig(InlineYaml(`
+				partials:
+				  - "_partials/*.tmpl"`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfg.Partials).To(Equal([]string{"_partials/*.tmpl"}))
+			})
+
+			g.It("parses the template suffix", func() {
+				cfg, err := config.ParseConfig(InlineYaml(`
+				template: ["templates/*"]
+				template-suffix: .tmpl`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfg.TemplateSuffix).To(Equal(".tmpl"))
+				Expect(cfg.Template).To(Equal([]string{"templates/*", "**.tmpl"}))
+				strip, ok := cfg.SuffixRename()
+				Expect(ok).To(BeTrue())
+				Expect(strip.Apply("chart/values.yml.tmpl")).To(Equal("chart/values.yml"))
+				Expect(strip.Check(".tmpl")).To(BeFalse())
+
+				_, err = config.ParseConfig([]byte("template-suffix: .d/tmpl"))
+				Expect(err).To(MatchError(config.ErrTemplateInvalid))
+			})
+
+			g.It("errors with bad template delimiters", func() {
+				for _, doc := range []string{
+					"template: [{delims: ['[[', ']]']}]",
+					"template: [{glob: '*.yml', delims: ['[[']}]",
+					"template: [{glob: '*.yml', delims: ['', ']]']}]",
+					"template: [{glob: '[', delims: ['[[', ']]']}]",
+				} {
+					_, err := config.ParseConfig([]byte(doc))
+					Expect(err).To(MatchError(config.ErrTemplateInvalid), doc)
+				}
+			})
+
+			g.It("parses policies", func() {
+				cfg, err := config.ParseConfig(InlineYaml(`
+				policies:
+				  - README.md: create-only
+				  - "*.yml": never-if-modified`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfg.Policies).To(Equal([]config.PolicyRule{
+					{Glob: "README.md", Policy: config.PolicyCreateOnly},
+					{Glob: "*.yml", Policy: config.PolicyNeverIfModified},
+				}))
+			})
+
+			g.It("errors with unknown policies", func() {
+				_, err := config.ParseConfig(InlineYaml(`
+				policies:
+				  - README.md: sometimes`))
+				Expect(err).To(MatchError(config.ErrPolicyInvalid))
+			})
+
+			g.It("parses upstream policies", func() {
+				cfg, err := config.ParseConfig(InlineYaml(`
+				upstream:
+				  - url: github.com/shakefu/commonrepo
+				    policy: never-if-modified
+				    policies:
+				      - CHANGELOG.md: create-only
+				  - url: github.com/shakefu/humbledb`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfg.Upstream[0].Policy).To(Equal(config.PolicyNeverIfModified))
+				Expect(cfg.Upstream[0].Policies).To(Equal([]config.PolicyRule{
+					{Glob: "CHANGELOG.md", Policy: config.PolicyCreateOnly},
+				}))
+				Expect(cfg.Upstream[1].Policy).To(BeEmpty())
+				Expect(cfg.Upstream[1].Policies).To(BeEmpty())
+			})
+
+			g.It("treats overwrite false as create-only", func() {
+				cfg, err := config.ParseConfig(InlineYaml(`
+				upstream:
+				  - url: github.com/shakefu/commonrepo
+				    overwrite: false
+				  - url: github.com/shakefu/humbledb
+				    overwrite: true`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfg.Upstream[0].Policy).To(Equal(config.PolicyCreateOnly))
+				Expect(cfg.Upstream[1].Policy).To(Equal(config.PolicyAlways))
+			})
+
+			g.It("errors with both overwrite and policy", func() {
+				_, err := config.ParseConfig(InlineYaml(`
+				upstream:
+				  - url: github.com/shakefu/commonrepo
+				    overwrite: false
+				    policy: always`))
+				Expect(err).To(MatchError(config.ErrPolicyConflict))
+			})
+
+			g.It("parses merges", func() {
+				cfg, err := config.ParseConfig(InlineYaml(`
+				merge:
+				  - glob: .golangci.yml
+				    lists: unique
+				    local: true
+				  - glob: "*.json"
+				    strategy: json
+				  - glob: .gitignore
+				    strategy: lines`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfg.Merge).To(Equal([]config.MergeRule{
+					{Glob: ".golangci.yml", Strategy: config.MergeAuto, Lists: config.ListsUnique, Local: true},
+					{Glob: "*.json", Strategy: config.MergeJSON, Lists: config.ListsReplace},
+					{Glob: ".gitignore", Strategy: config.MergeLines, Lists: config.ListsReplace},
+				}))
+				Expect(cfg.Merge[1].Match("renovate.json")).To(BeTrue())
+				Expect(cfg.Merge[1].Match("config/renovate.json")).To(BeFalse())
+			})
+
+			g.It("errors with invalid merges", func() {
+				for _, doc := range []string{
+					"merge: [{strategy: yaml}]",
+					"merge: [{glob: a.yml, strategy: xml}]",
+					"merge: [{glob: a.yml, lists: sometimes}]",
+					"merge: [{glob: a.yml, lists: append, local: true}]",
+					"merge: [{glob: '[a.yml'}]",
+				} {
+					_, err := config.ParseConfig([]byte(doc))
+					Expect(err).To(MatchError(config.ErrMergeInvalid), doc)
+				}
+			})
+
+			g.It("parses overrides", func() {
+				cfg, err := config.ParseConfig(InlineYaml(`
+				override:
+				  - .github/workflows/*.yml`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfg.Override).To(Equal([]string{".github/workflows/*.yml"}))
+			})
+
 			g.It("parses installs", func() {
 				config, err := config.ParseConfig(InlineYaml(`
 				install:
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+)
+
+// ErrUpstreamNotFound is returned when editing an upstream the config doesn't
+// have
+var ErrUpstreamNotFound = errors.New("upstream not found")
+
+// SetUpstreamRef returns the config data with the ref of the upstream at index
+// set to ref. Only the ref's value is changed, keeping its quoting, so every
+// comment and all the other formatting stays as it was. An upstream without a
+// ref gets one added after its url.
+func SetUpstreamRef(data []byte, index int, ref string) (edited []byte, err error) {
+	file, err := parser.ParseBytes(data, parser.ParseComments)
+	if err != nil {
+		return
+	}
+	path, err := yaml.PathString(fmt.Sprintf("$.upstream[%d]", index))
+	if err != nil {
+		return
+	}
+	node, err := path.FilterFile(file)
+	if err != nil || node == nil {
+		return nil, fmt.Errorf("%w: no upstream %d", ErrUpstreamNotFound, index)
+	}
+
+	// A mapping with a single key is parsed as just that key and value
+	var values []*ast.MappingValueNode
+	flow := false
+	switch mapping := node.(type) {
+	case *ast.MappingNode:
+		values, flow = mapping.Values, mapping.IsFlowStyle
+	case *ast.MappingValueNode:
+		values = []*ast.MappingValueNode{mapping}
+	default:
+		return nil, fmt.Errorf("%w: upstream %d isn't a mapping", ErrUpstreamNotFound, index)
+	}
+
+	var url *ast.MappingValueNode
+	for _, value := range values {
+		switch value.Key.GetToken().Value {
+		case "ref":
+			tk := value.Value.GetToken()
+			if _, null := value.Value.(*ast.NullNode); null {
+				return nil, fmt.Errorf("upstream %d has an empty ref", index)
+			}
+			raw := strings.TrimSpace(tk.Origin)
+			start := offset(data, tk.Position)
+			return splice(data, start, start+len(raw), quoteLike(raw, ref)), nil
+		case "url":
+			url = value
+		}
+	}
+	if url == nil {
+		return nil, fmt.Errorf("upstream %d has no url", index)
+	}
+
+	// Add the ref right after the url, in the same style
+	tk := url.Value.GetToken()
+	end := offset(data, tk.Position) + len(strings.TrimSpace(tk.Origin))
+	if flow {
+		return splice(data, end, end, ", ref: "+quoteLike("", ref)), nil
+	}
+	if newline := bytes.IndexByte(data[end:], '\n'); newline >= 0 {
+		end += newline
+	} else {
+		end = len(data)
+	}
+	indent := strings.Repeat(" ", url.Key.GetToken().Position.Column-1)
+	return splice(data, end, end, "\n"+indent+"ref: "+quoteLike("", ref)), nil
+}
+
+// offset returns the byte offset in data of the token position
+func offset(data []byte, pos *token.Position) (at int) {
+	for line := 1; line < pos.Line; line++ {
+		newline := bytes.IndexByte(data[at:], '\n')
+		if newline < 0 {
+			return len(data)
+		}
+		at += newline + 1
+	}
+	// Columns count characters, not bytes
+	for column := 1; column < pos.Column && at < len(data); column++ {
+		_, size := utf8.DecodeRune(data[at:])
+		at += size
+	}
+	return
+}
+
+// splice returns data with the bytes from start to end replaced
+func splice(data []byte, start int, end int, text string) []byte {
+	edited := make([]byte, 0, len(data)+len(text))
+	edited = append(edited, data[:start]...)
+	edited = append(edited, text...)
+	return append(edited, data[end:]...)
+}
+
+// quoteLike returns value as a YAML scalar quoted the same way as raw, or
+// only quoted if it has to be when raw isn't quoted
+func quoteLike(raw string, value string) string {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Quote(value)
+	case strings.HasPrefix(raw, "'"):
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	encoded, err := yaml.Marshal(value)
+	if err != nil {
+		return strconv.Quote(value)
+	}
+	return strings.TrimSpace(string(encoded))
+}
@@ -0,0 +1,81 @@
+package config_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/shakefu/commonrepo/internal/testutil"
+	"github.com/shakefu/commonrepo/pkg/config"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestEdit(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("SetUpstreamRef", func() {
+		data := InlineYaml(`
+		# Shared templates
+		upstream:
+		  # The org-wide base
+		  - url: github.com/example/base # keep me
+		    ref: "^1.4"  # patch releases
+		    include: ['**']
+		  - url: github.com/example/go
+		    ref: v1.0.0
+		  - {url: github.com/example/flow, ref: 'v2'}
+		  - url: github.com/example/latest # default branch
+		    exclude: ['*.md']
+		  - url: github.com/example/single
+		`)
+
+		// replaced returns data with old replaced by new
+		replaced := func(old string, new string) string {
+			Expect(string(data)).To(ContainSubstring(old))
+			return strings.Replace(string(data), old, new, 1)
+		}
+
+		g.It("only changes the ref's value", func() {
+			edited, err := config.SetUpstreamRef(data, 1, "v1.2.0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(edited)).To(Equal(replaced("ref: v1.0.0", "ref: v1.2.0")))
+		})
+
+		g.It("keeps the ref's quoting and comments", func() {
+			edited, err := config.SetUpstreamRef(data, 0, "^2.0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(edited)).To(Equal(replaced(`ref: "^1.4"  #`, `ref: "^2.0"  #`)))
+
+			edited, err = config.SetUpstreamRef(data, 2, "v3")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(edited)).To(Equal(replaced("ref: 'v2'}", "ref: 'v3'}")))
+		})
+
+		g.It("quotes refs which need it", func() {
+			edited, err := config.SetUpstreamRef(data, 1, ">=2.0.0 <3")
+			Expect(err).ToNot(HaveOccurred())
+			cfg, err := config.ParseConfig(edited)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Upstream[1].Ref).To(Equal(">=2.0.0 <3"))
+		})
+
+		g.It("adds a ref after the url", func() {
+			edited, err := config.SetUpstreamRef(data, 3, "v1.0.0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(edited)).To(Equal(replaced("latest # default branch\n",
+				"latest # default branch\n    ref: v1.0.0\n")))
+
+			edited, err = config.SetUpstreamRef(data, 4, "v1.0.0")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(edited)).To(Equal(replaced("single\n", "single\n    ref: v1.0.0\n")))
+		})
+
+		g.It("errors for upstreams it doesn't have", func() {
+			_, err := config.SetUpstreamRef(data, 5, "v1.0.0")
+			Expect(errors.Is(err, config.ErrUpstreamNotFound)).To(BeTrue())
+		})
+	})
+}
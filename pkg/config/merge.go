@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/gobwas/glob"
+)
+
+// MergeStrategy is how the layers of a merged target are combined
+type MergeStrategy string
+
+const (
+	// MergeAuto picks the strategy from the file extension
+	MergeAuto MergeStrategy = ""
+	// MergeYAML deep merges YAML documents
+	MergeYAML MergeStrategy = "yaml"
+	// MergeJSON deep merges JSON documents
+	MergeJSON MergeStrategy = "json"
+	// MergeTOML deep merges TOML documents
+	MergeTOML MergeStrategy = "toml"
+	// MergeLines unions the lines of ignore style files and the local file
+	MergeLines MergeStrategy = "lines"
+	// MergeBlock writes each upstream's content between markers in the local
+	// file, leaving the rest of it alone
+	MergeBlock MergeStrategy = "block"
+)
+
+// MergeLists is how lists are combined when deep merging documents
+type MergeLists string
+
+const (
+	// ListsReplace uses the list from the last layer, which is the default
+	ListsReplace MergeLists = "replace"
+	// ListsAppend concatenates the lists from every layer
+	ListsAppend MergeLists = "append"
+	// ListsUnique concatenates the lists, dropping repeated items
+	ListsUnique MergeLists = "unique"
+)
+
+// MergeRule merges every layer of the targets matching a glob instead of
+// letting the last upstream win
+type MergeRule struct {
+	Glob     string        // Target names to merge
+	Strategy MergeStrategy // How to combine the layers
+	Lists    MergeLists    // How to combine lists within the layers
+	Local    bool          // Whether the existing local file is the first layer
+}
+
+// String gives us a string representation of the merge rule
+func (rule *MergeRule) String() string {
+	return fmt.Sprintf("%s: %s", rule.Glob, rule.Strategy)
+}
+
+// Match returns true if the rule applies to the target name
+func (rule *MergeRule) Match(name string) bool {
+	// The glob was already checked when it was parsed
+	g, err := glob.Compile(rule.Glob, filepath.Separator)
+	return err == nil && g.Match(name)
+}
+
+// ParseMergeStrategy returns the MergeStrategy with the given name
+func ParseMergeStrategy(name string) (strategy MergeStrategy, err error) {
+	strategy = MergeStrategy(name)
+	switch strategy {
+	case MergeAuto, MergeYAML, MergeJSON, MergeTOML, MergeLines, MergeBlock:
+		return
+	}
+	return "", fmt.Errorf("%w: unknown strategy %q", ErrMergeInvalid, name)
+}
+
+// ParseMergeLists returns the MergeLists with the given name, defaulting to
+// replace
+func ParseMergeLists(name string) (lists MergeLists, err error) {
+	lists = MergeLists(name)
+	switch lists {
+	case "":
+		return ListsReplace, nil
+	case ListsReplace, ListsAppend, ListsUnique:
+		return
+	}
+	return "", fmt.Errorf("%w: unknown lists %q", ErrMergeInvalid, name)
+}
+
+// parseMerges parses and returns a list of merge rules
+func parseMerges(merges []yamlMerge) (parsed []MergeRule, err error) {
+	parsed = []MergeRule{}
+	for _, item := range merges {
+		rule := MergeRule{Glob: item.Glob, Local: item.Local}
+		if rule.Glob == "" {
+			return nil, fmt.Errorf("%w: missing glob", ErrMergeInvalid)
+		}
+		if _, err = glob.Compile(rule.Glob, filepath.Separator); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrMergeInvalid, rule.Glob, err)
+		}
+		if rule.Strategy, err = ParseMergeStrategy(item.Strategy); err != nil {
+			return nil, err
+		}
+		if rule.Lists, err = ParseMergeLists(item.Lists); err != nil {
+			return nil, err
+		}
+		// Appending to the local file every run would grow its lists forever
+		if rule.Local && rule.Lists == ListsAppend {
+			return nil, fmt.Errorf("%w: %s appends lists to the local file, use unique instead",
+				ErrMergeInvalid, rule.Glob)
+		}
+		parsed = append(parsed, rule)
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Policy controls when a target is allowed to overwrite a local file
+type Policy string
+
+const (
+	// PolicyAlways writes the target every time, which is the default
+	PolicyAlways Policy = "always"
+	// PolicyCreateOnly seeds the file once then leaves it to the downstream
+	PolicyCreateOnly Policy = "create-only"
+	// PolicyNeverIfModified skips the file if it changed since we last wrote it
+	PolicyNeverIfModified Policy = "never-if-modified"
+)
+
+// ParsePolicy returns the Policy with the given name
+func ParsePolicy(name string) (policy Policy, err error) {
+	policy = Policy(name)
+	switch policy {
+	case PolicyAlways, PolicyCreateOnly, PolicyNeverIfModified:
+		return
+	}
+	return "", fmt.Errorf("%w: %q", ErrPolicyInvalid, name)
+}
+
+// PolicyRule applies a Policy to the targets matching a glob
+type PolicyRule struct {
+	Glob   string
+	Policy Policy
+}
+
+// String gives us a string representation of the policy rule
+func (rule *PolicyRule) String() string {
+	return rule.Glob + ": " + string(rule.Policy)
+}
+
+// parsePolicies parses and returns a list of policy rules
+func parsePolicies(policies []map[string]string) (parsed []PolicyRule, err error) {
+	var policy Policy
+	parsed = []PolicyRule{}
+	// Iterate over our list of policy maps
+	for _, item := range policies {
+		for glob, name := range item {
+			if policy, err = ParsePolicy(name); err != nil {
+				return
+			}
+			parsed = append(parsed, PolicyRule{glob, policy})
+		}
+	}
+	return
+}
+
+// parseUpstreamPolicy returns the upstream-wide policy from either the policy
+// or the overwrite shorthand
+func parseUpstreamPolicy(item yamlUpstream) (policy Policy, err error) {
+	if item.Overwrite != nil {
+		if item.Policy != "" {
+			return "", ErrPolicyConflict
+		}
+		if *item.Overwrite {
+			return PolicyAlways, nil
+		}
+		return PolicyCreateOnly, nil
+	}
+	if item.Policy == "" {
+		return
+	}
+	return ParsePolicy(item.Policy)
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// yamlTemplate is a template entry, which is either a glob or a mapping with
+// the glob and its options
+type yamlTemplate struct {
+	Glob   string   `yaml:"glob"`
+	Delims []string `yaml:"delims"`
+}
+
+// UnmarshalYAML accepts a plain glob as well as the mapping
+func (item *yamlTemplate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&item.Glob); err == nil {
+		return nil
+	}
+	type plain yamlTemplate
+	return unmarshal((*plain)(item))
+}
+
+// DelimRule changes the action delimiters of the templates matching a glob, for
+// files which already use {{ and }} themselves
+type DelimRule struct {
+	Glob  string // Template names the delimiters apply to
+	Left  string // Opens an action, instead of {{
+	Right string // Closes an action, instead of }}
+}
+
+// String gives us a string representation of the delimiter rule
+func (rule *DelimRule) String() string {
+	return fmt.Sprintf("%s: %s %s", rule.Glob, rule.Left, rule.Right)
+}
+
+// parseTemplates returns the globs of the template entries, and the delimiter
+// rules of the ones which set delims
+func parseTemplates(templates []yamlTemplate) (globs []string, delims []DelimRule, err error) {
+	globs = []string{}
+	delims = []DelimRule{}
+	for _, item := range templates {
+		if item.Glob == "" {
+			return nil, nil, fmt.Errorf("%w: missing glob", ErrTemplateInvalid)
+		}
+		globs = append(globs, item.Glob)
+		if item.Delims == nil {
+			continue
+		}
+		if len(item.Delims) != 2 || item.Delims[0] == "" || item.Delims[1] == "" {
+			return nil, nil, fmt.Errorf("%w: %s: delims must be a left and a right delimiter",
+				ErrTemplateInvalid, item.Glob)
+		}
+		if _, err = glob.Compile(item.Glob, filepath.Separator); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s: %v", ErrTemplateInvalid, item.Glob, err)
+		}
+		delims = append(delims, DelimRule{item.Glob, item.Delims[0], item.Delims[1]})
+	}
+	return
+}
+
+// parseTemplateSuffix checks the template suffix and returns the glob matching
+// every file with it, or an empty glob if there's no suffix
+func parseTemplateSuffix(suffix string) (pattern string, err error) {
+	if suffix == "" {
+		return
+	}
+	if strings.Contains(suffix, "/") {
+		return "", fmt.Errorf("%w: template-suffix %q can't have a /", ErrTemplateInvalid, suffix)
+	}
+	return "**" + glob.QuoteMeta(suffix), nil
+}
+
+// SuffixRename returns the rename which strips the template suffix from a
+// destination, and false if there's no template suffix
+func (config *Config) SuffixRename() (rename Rename, ok bool) {
+	if config.TemplateSuffix == "" {
+		return
+	}
+	return Rename{
+		Match:   regexp.MustCompile("^(.+)" + regexp.QuoteMeta(config.TemplateSuffix) + "$"),
+		Replace: "%[1]s",
+	}, true
+}
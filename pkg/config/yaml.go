@@ -9,10 +9,15 @@ import (
 type YamlConfig struct {
 	// Source options
 	YamlSource  `yaml:",inline"`
-	Template    []string            `yaml:"template"`
+	Template    []string            `yaml:"-"` // Globs of the template entries
+	Templates   []yamlTemplate      `yaml:"template"`
+	Partials    []string            `yaml:"partials"`
+	Suffix      string              `yaml:"template-suffix"`
 	Install     []map[string]string `yaml:"install"`
 	InstallFrom string              `yaml:"install-from"`
 	InstallWith []string            `yaml:"install-with"`
+	Merge       []yamlMerge         `yaml:"merge"`
+	Override    []string            `yaml:"override"`
 	// Consumer options
 	Upstream     []yamlUpstream         `yaml:"upstream"`
 	TemplateVars map[string]interface{} `yaml:"template-vars"`
@@ -21,19 +26,36 @@ type YamlConfig struct {
 }
 
 type YamlSource struct {
-	Include []string
-	Exclude []string
-	Rename  []map[string]string
+	Include  []string
+	Exclude  []string
+	Rename   []map[string]string
+	Policies []map[string]string
 }
 
 type yamlUpstream struct {
 	URL        string `yaml:"url"`
 	Ref        string `yaml:"ref"`
+	Policy     string `yaml:"policy"`
+	Overwrite  *bool  `yaml:"overwrite"`
+	Prerelease bool   `yaml:"prerelease"`
 	YamlSource `yaml:",inline"`
 }
 
+type yamlMerge struct {
+	Glob     string `yaml:"glob"`
+	Strategy string `yaml:"strategy"`
+	Lists    string `yaml:"lists"`
+	Local    bool   `yaml:"local"`
+}
+
 var (
-	ErrRenameInvalid = errors.New("rename entry is not valid")
+	ErrRenameInvalid   = errors.New("rename entry is not valid")
+	ErrPolicyInvalid   = errors.New("policy is not valid")
+	ErrPolicyConflict  = errors.New("upstream cannot set both overwrite and policy")
+	ErrMergeInvalid    = errors.New("merge entry is not valid")
+	ErrRefInvalid      = errors.New("ref is not valid")
+	ErrVarReserved     = errors.New("template variable is reserved")
+	ErrTemplateInvalid = errors.New("template entry is not valid")
 )
 
 // Unmarshal data into this YamlConfig
@@ -42,6 +64,9 @@ func (config *YamlConfig) Unmarshal(data []byte) (err error) {
 	err = yaml.Unmarshal(data, config)
 	// TODO: Handle making pretty error messages for when config fails parsing.
 	// This would be super useful for the CLI output to be really nice.
+	for _, template := range config.Templates {
+		config.Template = append(config.Template, template.Glob)
+	}
 	return
 }
 
@@ -2,11 +2,16 @@
 package gitutil
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -14,8 +19,20 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport/client"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/imdario/mergo"
+	"github.com/shakefu/commonrepo/pkg/common"
 )
 
+// ErrOffline is returned for anything which would contact a remote
+// repository while running offline
+var ErrOffline = errors.New("running offline")
+
+// ErrUnknownRef is returned for a ref which isn't a branch, tag or commit
+var ErrUnknownRef = errors.New("unknown ref")
+
+// ErrAmbiguousRef is returned for an abbreviated commit SHA which matches more
+// than one commit
+var ErrAmbiguousRef = errors.New("ambiguous ref")
+
 // FindLocalRepoPath returns the full path to the repository containing the
 // current working directory. This is not really useful for anything except
 // providing a shortcut to that path for tests, and maybe automation tools.
@@ -66,6 +83,44 @@ func DetectGitPath(path string) (string, error) {
 	}
 }
 
+// RepoName returns the name of the repository containing the local path, which
+// is the last part of its origin remote's URL, or of its root directory if it
+// has no origin.
+func RepoName(dir string) (name string, err error) {
+	repository, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return
+	}
+	if origin, err := repository.Remote("origin"); err == nil && len(origin.Config().URLs) > 0 {
+		return filepath.Base(NormalizeURL(origin.Config().URLs[0])), nil
+	}
+	tree, err := repository.Worktree()
+	if err != nil {
+		return
+	}
+	return filepath.Base(tree.Filesystem.Root()), nil
+}
+
+// LocalDefaultBranch returns the default branch of the repository containing
+// the local path, without asking the remote. That's the branch origin/HEAD
+// points at, or the checked out branch if there's no origin/HEAD, or an empty
+// string if HEAD is detached.
+func LocalDefaultBranch(dir string) (branch string, err error) {
+	repository, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return
+	}
+	origin, err := repository.Reference(plumbing.NewRemoteHEADReferenceName("origin"), false)
+	if err == nil && origin.Type() == plumbing.SymbolicReference {
+		return strings.TrimPrefix(origin.Target().Short(), "origin/"), nil
+	}
+	head, err := repository.Reference(plumbing.HEAD, false)
+	if err != nil || !head.Target().IsBranch() {
+		return
+	}
+	return head.Target().Short(), nil
+}
+
 // DefaultRef returns a ref pointing at the default HEAD for the repository,
 // since not all repositories will use "main", this will determine it for us.
 func DefaultRef(url string) (ref plumbing.ReferenceName, err error) {
@@ -134,18 +189,33 @@ func RemoteDefaultBranch() (ref plumbing.ReferenceName, err error) {
 	return
 }
 
-// FindRef returns a ref from the given refname, or falls back to the default
-// branch for the repository.
+// FindRef returns a ref from the given refname, or the default branch for the
+// repository when refname is empty. See MatchRef for how it's matched.
 func FindRef(url string, refname string) (ref plumbing.ReferenceName, err error) {
-	refs, err := GetRefs(url)
+	return FindRefContext(context.Background(), url, refname)
+}
+
+// FindRefContext is FindRef which gives up when the context is done.
+func FindRefContext(ctx context.Context, url string, refname string) (ref plumbing.ReferenceName, err error) {
+	refs, err := GetRefsContext(ctx, url)
 	if err != nil {
 		return
 	}
+	return MatchRef(refs, refname)
+}
 
+// MatchRef returns the full name of refname in the refs, trying it as a full
+// name, a tag and then a branch, or the default branch when refname is empty.
+//
+// A commit SHA is returned as the name itself, expanded to the full hash when
+// it's abbreviated and one of the refs points at it. Anything else which isn't
+// found is an ErrUnknownRef listing the closest tags and branches.
+func MatchRef(refs memory.ReferenceStorage, refname string) (ref plumbing.ReferenceName, err error) {
 	// Handle the default case without processing all the refs
 	if refname == "" {
-		// Pull out just the default HEAD ref
-		ref = refs["HEAD"].Target()
+		if ref = defaultBranch(refs); ref == "" {
+			err = errors.New("no default branch")
+		}
 		return
 	}
 
@@ -159,20 +229,174 @@ func FindRef(url string, refname string) (ref plumbing.ReferenceName, err error)
 			return ref, nil
 		}
 	}
-	ref = refs["HEAD"].Target()
+
+	if !IsHash(refname) {
+		return "", unknownRef(refs, refname)
+	}
+	refname = strings.ToLower(refname)
+	if len(refname) == len(plumbing.ZeroHash.String()) {
+		return plumbing.ReferenceName(refname), nil
+	}
+	// Abbreviated hashes which aren't a tip are found in the history later
+	found := make(map[plumbing.Hash]bool)
+	for _, each := range refs {
+		if each.Type() == plumbing.HashReference && strings.HasPrefix(each.Hash().String(), refname) {
+			found[each.Hash()] = true
+		}
+	}
+	if len(found) > 1 {
+		return "", fmt.Errorf("%w: %q matches %d commits", ErrAmbiguousRef, refname, len(found))
+	}
+	for hash := range found {
+		return plumbing.ReferenceName(hash.String()), nil
+	}
+	return plumbing.ReferenceName(refname), nil
+}
+
+// MatchVersion returns the tag in the refs with the highest semver version
+// which satisfies the constraints. Pre-release versions are only matched when
+// prerelease is true, and then as if they were the release they precede.
+func MatchVersion(refs memory.ReferenceStorage, constraints *semver.Constraints, prerelease bool) (ref plumbing.ReferenceName, err error) {
+	var versions []*semver.Version
+	var best *semver.Version
+	for name := range refs {
+		if !name.IsTag() {
+			continue
+		}
+		version, err := semver.NewVersion(name.Short())
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+
+		release := version
+		if prerelease && version.Prerelease() != "" {
+			stripped, _ := version.SetPrerelease("")
+			release = &stripped
+		}
+		if !constraints.Check(release) {
+			continue
+		}
+		// Tie break equal versions like 1.0.0 and v1.0.0 so it's repeatable
+		if best == nil || version.GreaterThan(best) || version.Equal(best) && name < ref {
+			best, ref = version, name
+		}
+	}
+	if ref != "" {
+		return
+	}
+
+	if len(versions) == 0 {
+		return "", fmt.Errorf("%w: no tag matches %q, there are no version tags",
+			ErrUnknownRef, constraints.String())
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].GreaterThan(versions[j])
+	})
+	var newest []string
+	for _, version := range versions[:min(3, len(versions))] {
+		newest = append(newest, version.Original())
+	}
+	return "", fmt.Errorf("%w: no tag matches %q, newest are %s",
+		ErrUnknownRef, constraints.String(), strings.Join(newest, ", "))
+}
+
+// IsHash returns true if the refname looks like a full or abbreviated commit
+// SHA, which is at least 7 hex characters.
+func IsHash(refname string) bool {
+	if len(refname) < 7 || len(refname) > len(plumbing.ZeroHash.String()) {
+		return false
+	}
+	for _, c := range strings.ToLower(refname) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// unknownRef returns an ErrUnknownRef for refname, listing the closest tags
+// and branches in the refs
+func unknownRef(refs memory.ReferenceStorage, refname string) error {
+	closest := Closest(refs, refname, 3)
+	if len(closest) == 0 {
+		return fmt.Errorf("%w %q", ErrUnknownRef, refname)
+	}
+	return fmt.Errorf("%w %q, closest are %s", ErrUnknownRef, refname, strings.Join(closest, ", "))
+}
+
+// Closest returns up to n of the tag and branch names in the refs which are
+// the fewest edits away from refname, closest first.
+func Closest(refs memory.ReferenceStorage, refname string, n int) (closest []string) {
+	distance := make(map[string]int)
+	for name := range refs {
+		if !name.IsBranch() && !name.IsTag() {
+			continue
+		}
+		short := name.Short()
+		closest = append(closest, short)
+		distance[short] = editDistance(refname, short)
+	}
+	sort.Slice(closest, func(i, j int) bool {
+		if distance[closest[i]] != distance[closest[j]] {
+			return distance[closest[i]] < distance[closest[j]]
+		}
+		return closest[i] < closest[j]
+	})
+	if len(closest) > n {
+		closest = closest[:n]
+	}
 	return
 }
 
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	x, y := []rune(a), []rune(b)
+	prev := make([]int, len(y)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		cur := make([]int, len(y)+1)
+		cur[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(y)]
+}
+
+// defaultBranch returns the ref HEAD points to, if there is one
+func defaultBranch(refs memory.ReferenceStorage) plumbing.ReferenceName {
+	if head, ok := refs[plumbing.HEAD]; ok {
+		return head.Target()
+	}
+	return ""
+}
+
 // GetRefs returns a map of all the available refs
 //
 // This is borrowed from:
 // https://github.com/go-git/go-git/issues/249#issuecomment-772354474
 func GetRefs(url string) (refs memory.ReferenceStorage, err error) {
+	return GetRefsContext(context.Background(), url)
+}
+
+// GetRefsContext is GetRefs which gives up when the context is done.
+func GetRefsContext(ctx context.Context, url string) (refs memory.ReferenceStorage, err error) {
 	// Get the endpoint config and determine transport type
 	end, err := transport.NewEndpoint(url)
 	if err != nil {
 		return
 	}
+	if common.Offline() && end.Protocol != "file" {
+		return nil, fmt.Errorf("%w: can't list refs for %s", ErrOffline, url)
+	}
 
 	// Get a client instance for the given transport type
 	cli, err := client.NewClient(end)
@@ -185,9 +409,10 @@ func GetRefs(url string) (refs memory.ReferenceStorage, err error) {
 	if err != nil {
 		return
 	}
+	defer sess.Close()
 
 	// Get all the references
-	info, err := sess.AdvertisedReferences()
+	info, err := sess.AdvertisedReferencesContext(ctx)
 	if err != nil {
 		return
 	}
@@ -250,3 +475,47 @@ func LoadGitConfig() (conf *config.Config, err error) {
 	_ = mergo.Merge(conf, system)
 	return conf, nil
 }
+
+// NormalizeURL returns a canonical form of the repository URL so the same
+// repository can be recognized however it was written. The scheme, user and
+// trailing ".git" are dropped, scp-like ssh URLs are turned into host/path, and
+// local paths are made absolute.
+func NormalizeURL(remoteURL string) string {
+	normal := strings.TrimSpace(remoteURL)
+
+	switch {
+	case strings.Contains(normal, "://"):
+		if parsed, err := url.Parse(normal); err == nil {
+			normal = strings.ToLower(parsed.Host) + parsed.Path
+		}
+	case isSCPLike(normal):
+		host, path, _ := strings.Cut(normal, ":")
+		if _, after, ok := strings.Cut(host, "@"); ok {
+			host = after
+		}
+		normal = strings.ToLower(host) + "/" + strings.TrimPrefix(path, "/")
+	default:
+		if abs, err := filepath.Abs(normal); err == nil {
+			return abs
+		}
+	}
+
+	normal = strings.TrimSuffix(normal, "/")
+	normal = strings.TrimSuffix(normal, ".git")
+	return normal
+}
+
+// IsLocal returns true if the URL is a repository on this machine, which can
+// be used while running offline
+func IsLocal(remoteURL string) bool {
+	end, err := transport.NewEndpoint(remoteURL)
+	return err == nil && end.Protocol == "file"
+}
+
+// isSCPLike returns true for ssh URLs like git@github.com:org/repo
+func isSCPLike(remoteURL string) bool {
+	colon := strings.Index(remoteURL, ":")
+	slash := strings.Index(remoteURL, "/")
+	// A single letter before the colon is a Windows drive, not a host
+	return colon > 1 && (slash < 0 || colon < slash)
+}
@@ -1,10 +1,17 @@
 package gitutil
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
 	. "github.com/onsi/gomega"
 	goblin "github.com/shakefu/goblin"
 )
@@ -52,6 +59,45 @@ func TestGitUtil(t *testing.T) {
 			})
 		})
 
+		g.Describe("RepoName", func() {
+			g.It("uses the origin remote", func() {
+				dir, err := os.MkdirTemp("", "commonrepo-named-")
+				Expect(err).ToNot(HaveOccurred())
+				defer os.RemoveAll(dir)
+				repository, err := git.PlainInit(dir, false)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(RepoName(dir)).To(Equal(filepath.Base(dir)))
+				_, err = repository.CreateRemote(&config.RemoteConfig{
+					Name: "origin",
+					URLs: []string{"git@github.com:example/named.git"},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(RepoName(dir)).To(Equal("named"))
+			})
+		})
+
+		g.Describe("LocalDefaultBranch", func() {
+			g.It("uses origin/HEAD, then the checked out branch", func() {
+				dir, err := os.MkdirTemp("", "commonrepo-branch-")
+				Expect(err).ToNot(HaveOccurred())
+				defer os.RemoveAll(dir)
+				repository, err := git.PlainInit(dir, false)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(repository.Storer.SetReference(plumbing.NewSymbolicReference(
+					plumbing.HEAD, plumbing.NewBranchReferenceName("work")))).To(Succeed())
+
+				Expect(LocalDefaultBranch(dir)).To(Equal("work"))
+				Expect(repository.Storer.SetReference(plumbing.NewSymbolicReference(
+					plumbing.NewRemoteHEADReferenceName("origin"),
+					plumbing.NewRemoteReferenceName("origin", "trunk")))).To(Succeed())
+				Expect(repository.Storer.SetReference(plumbing.NewHashReference(
+					plumbing.NewRemoteReferenceName("origin", "trunk"),
+					plumbing.NewHash("8d04be7c19b3d251c7e0a3f2a9c18d04be7c19b3")))).To(Succeed())
+				Expect(LocalDefaultBranch(dir)).To(Equal("trunk"))
+			})
+		})
+
 		g.Describe("RemoteDefaultBranch", func() {
 			g.It("works", func() {
 				ref, err := RemoteDefaultBranch()
@@ -78,6 +124,84 @@ func TestGitUtil(t *testing.T) {
 			})
 		})
 
+		g.Describe("MatchRef", func() {
+			const tip = "3f786850e387550fdab836ed7e6dc881de23001b"
+			refs := memory.ReferenceStorage{}
+			for _, ref := range []*plumbing.Reference{
+				plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main"),
+				plumbing.NewHashReference("refs/heads/main", plumbing.NewHash(tip)),
+				plumbing.NewHashReference("refs/heads/develop", plumbing.NewHash(tip)),
+				plumbing.NewHashReference("refs/tags/v1.2.3", plumbing.NewHash(tip)),
+				plumbing.NewHashReference("refs/tags/v1.3.0", plumbing.NewHash(tip)),
+			} {
+				refs[ref.Name()] = ref
+			}
+
+			g.It("finds tags and branches", func() {
+				Expect(MatchRef(refs, "v1.2.3")).To(BeEquivalentTo("refs/tags/v1.2.3"))
+				Expect(MatchRef(refs, "develop")).To(BeEquivalentTo("refs/heads/develop"))
+				Expect(MatchRef(refs, "refs/heads/main")).To(BeEquivalentTo("refs/heads/main"))
+				Expect(MatchRef(refs, "")).To(BeEquivalentTo("refs/heads/main"))
+			})
+
+			g.It("errors with the closest refs instead of using the default branch", func() {
+				_, err := MatchRef(refs, "v1.2.4")
+				Expect(errors.Is(err, ErrUnknownRef)).To(BeTrue())
+				Expect(err).To(MatchError(`unknown ref "v1.2.4", closest are v1.2.3, v1.3.0, main`))
+			})
+
+			g.It("takes commit SHAs", func() {
+				other := "0123456789abcdef0123456789abcdef01234567"
+				Expect(MatchRef(refs, other)).To(BeEquivalentTo(other))
+				Expect(MatchRef(refs, strings.ToUpper(other))).To(BeEquivalentTo(other))
+				Expect(MatchRef(refs, tip[:7])).To(BeEquivalentTo(tip))
+				Expect(MatchRef(refs, "0123456")).To(BeEquivalentTo("0123456"))
+			})
+
+			g.It("doesn't take short hex as a SHA", func() {
+				_, err := MatchRef(refs, "beef")
+				Expect(errors.Is(err, ErrUnknownRef)).To(BeTrue())
+			})
+		})
+
+		g.Describe("MatchVersion", func() {
+			refs := memory.ReferenceStorage{}
+			for _, name := range []string{
+				"refs/heads/main",
+				"refs/heads/v9.0.0",
+				"refs/tags/v1.3.9",
+				"refs/tags/v1.4.0",
+				"refs/tags/v1.4.2",
+				"refs/tags/v1.5.0-rc.1",
+				"refs/tags/2.0.0",
+				"refs/tags/latest",
+			} {
+				refs[plumbing.ReferenceName(name)] = plumbing.NewHashReference(
+					plumbing.ReferenceName(name), plumbing.ZeroHash)
+			}
+
+			g.It("finds the highest matching tag", func() {
+				caret, _ := semver.NewConstraint("^1.4")
+				Expect(MatchVersion(refs, caret, false)).To(BeEquivalentTo("refs/tags/v1.4.2"))
+				tilde, _ := semver.NewConstraint("~1.3")
+				Expect(MatchVersion(refs, tilde, false)).To(BeEquivalentTo("refs/tags/v1.3.9"))
+				between, _ := semver.NewConstraint(">=2.0.0 <3")
+				Expect(MatchVersion(refs, between, false)).To(BeEquivalentTo("refs/tags/2.0.0"))
+			})
+
+			g.It("only matches pre-releases when asked", func() {
+				caret, _ := semver.NewConstraint("^1.4")
+				Expect(MatchVersion(refs, caret, true)).To(BeEquivalentTo("refs/tags/v1.5.0-rc.1"))
+			})
+
+			g.It("errors with the newest tags when nothing matches", func() {
+				caret, _ := semver.NewConstraint("^3")
+				_, err := MatchVersion(refs, caret, false)
+				Expect(errors.Is(err, ErrUnknownRef)).To(BeTrue())
+				Expect(err).To(MatchError(`unknown ref: no tag matches "^3", newest are 2.0.0, v1.5.0-rc.1, v1.4.2`))
+			})
+		})
+
 		g.Describe("ApplyInsteadOf", func() {
 			g.It("works", func() {
 				// Exercise test, it doesn't really do anything testable
@@ -87,6 +211,58 @@ func TestGitUtil(t *testing.T) {
 			})
 		})
 
+		g.Describe("offline", func() {
+			g.Before(func() {
+				os.Setenv("COMMON_OFFLINE", "1")
+			})
+
+			g.After(func() {
+				os.Unsetenv("COMMON_OFFLINE")
+			})
+
+			g.It("won't list remote refs", func() {
+				_, err := GetRefs("https://github.com/shakefu/commonrepo")
+				Expect(errors.Is(err, ErrOffline)).To(BeTrue())
+			})
+
+			g.It("still lists local refs", func() {
+				refs, err := GetRefs("../..")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(refs).To(HaveKey(plumbing.HEAD))
+			})
+		})
+
+		g.Describe("IsLocal", func() {
+			g.It("knows local paths from remotes", func() {
+				Expect(IsLocal(".")).To(BeTrue())
+				Expect(IsLocal("/tmp/repo")).To(BeTrue())
+				Expect(IsLocal("file:///tmp/repo")).To(BeTrue())
+				Expect(IsLocal("https://github.com/shakefu/commonrepo")).To(BeFalse())
+				Expect(IsLocal("git@github.com:shakefu/commonrepo.git")).To(BeFalse())
+			})
+		})
+
+		g.Describe("NormalizeURL", func() {
+			g.It("treats every form of a remote the same", func() {
+				for _, remote := range []string{
+					"https://github.com/shakefu/commonrepo",
+					"https://github.com/shakefu/commonrepo.git",
+					"https://user@GitHub.com/shakefu/commonrepo/",
+					"ssh://git@github.com/shakefu/commonrepo.git",
+					"git@github.com:shakefu/commonrepo.git",
+				} {
+					Expect(NormalizeURL(remote)).To(Equal("github.com/shakefu/commonrepo"))
+				}
+			})
+
+			g.It("makes local paths absolute", func() {
+				cwd, err := os.Getwd()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(NormalizeURL(".")).To(Equal(cwd))
+				Expect(NormalizeURL("./")).To(Equal(cwd))
+			})
+		})
+
 		g.Describe("(external)", func() {
 			g.SkipIf(os.Getenv("SKIP_EXTERNAL") != "")
 			g.SkipIf(os.Getenv("SSH_AUTH_SOCK") == "")
@@ -121,11 +297,10 @@ func TestGitUtil(t *testing.T) {
 					Expect(ref).To(BeEquivalentTo("refs/tags/6.0.0"))
 				})
 
-				g.It("returns the default branch if it can't find the ref", func() {
+				g.It("errors if it can't find the ref", func() {
 					url := "git@github.com:shakefu/humbledb.git"
-					ref, err := FindRef(url, "badtag")
-					Expect(err).ShouldNot(HaveOccurred())
-					Expect(ref).To(BeEquivalentTo("refs/heads/master"))
+					_, err := FindRef(url, "badtag")
+					Expect(errors.Is(err, ErrUnknownRef)).To(BeTrue())
 				})
 
 				g.It("returns the default branch for an empty ref", func() {
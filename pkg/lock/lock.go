@@ -0,0 +1,132 @@
+// Package lock provides the lockfile, which records the resolved state of every
+// upstream so composites can be reproduced, and the manifest, which records the
+// files written to the repository
+package lock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/goccy/go-yaml"
+	"github.com/shakefu/commonrepo/pkg/common"
+)
+
+// FileName is the name of the lockfile, written next to the .commonrepo.yml
+const FileName = ".commonrepo.lock"
+
+// header is prepended to every file we generate
+const header = "# This file is generated by commonrepo. Do not edit it by hand.\n"
+
+var (
+	ErrMismatch = errors.New("lockfile does not match the resolved upstreams")
+)
+
+// Lock records the resolved state of every flattened upstream
+type Lock struct {
+	Upstream []Upstream `yaml:"upstream"`
+}
+
+// Upstream is the resolved state of a single upstream
+type Upstream struct {
+	URL      string            `yaml:"url"`      // Requested URL
+	Ref      string            `yaml:"ref"`      // Requested ref
+	Resolved string            `yaml:"resolved"` // Full reference the ref resolved to
+	Commit   string            `yaml:"commit"`   // Commit hash that was cloned
+	Files    map[string]string `yaml:"files"`    // Target names to content hashes
+}
+
+// String returns url@ref for the upstream
+func (upstream *Upstream) String() string {
+	return upstream.URL + "@" + upstream.Ref
+}
+
+// Hash returns the content hash we record for target files
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Parse returns a Lock from the given yaml data
+func Parse(data []byte) (lock *Lock, err error) {
+	lock = &Lock{}
+	if err = yaml.Unmarshal(data, lock); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Read returns the Lock stored at path in the given filesystem
+func Read(fs billy.Filesystem, path string) (lock *Lock, err error) {
+	var data []byte
+	if data, err = util.ReadFile(fs, path); err != nil {
+		return
+	}
+	return Parse(data)
+}
+
+// Marshal returns the yaml representation of the Lock
+func (lock *Lock) Marshal() (data []byte, err error) {
+	var body []byte
+	if body, err = yaml.Marshal(lock); err != nil {
+		return
+	}
+	return append([]byte(header), body...), nil
+}
+
+// Write stores the Lock at path in the given filesystem
+func (lock *Lock) Write(fs billy.Filesystem, path string) (err error) {
+	var data []byte
+	if data, err = lock.Marshal(); err != nil {
+		return
+	}
+	return util.WriteFile(fs, path, data, 0644)
+}
+
+// Verify returns an error describing every difference between this Lock and
+// the resolved state, or nil if they agree
+func (lock *Lock) Verify(resolved *Lock) error {
+	var problems []string
+	if len(lock.Upstream) != len(resolved.Upstream) {
+		problems = append(problems, fmt.Sprintf("locked %d upstreams, resolved %d",
+			len(lock.Upstream), len(resolved.Upstream)))
+	}
+
+	for i := 0; i < len(lock.Upstream) && i < len(resolved.Upstream); i++ {
+		locked, actual := lock.Upstream[i], resolved.Upstream[i]
+		if locked.URL != actual.URL || locked.Ref != actual.Ref {
+			problems = append(problems, fmt.Sprintf("upstream %d: locked %s, resolved %s",
+				i, locked.String(), actual.String()))
+			continue
+		}
+		if locked.Commit != actual.Commit {
+			problems = append(problems, fmt.Sprintf("%s: locked commit %s, resolved %s",
+				locked.String(), locked.Commit, actual.Commit))
+		}
+		for _, name := range common.SortedKeys(locked.Files) {
+			hash, ok := actual.Files[name]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: %s is no longer provided",
+					locked.String(), name))
+			} else if hash != locked.Files[name] {
+				problems = append(problems, fmt.Sprintf("%s: %s content changed",
+					locked.String(), name))
+			}
+		}
+		for _, name := range common.SortedKeys(actual.Files) {
+			if _, ok := locked.Files[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: %s is newly provided",
+					locked.String(), name))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w:\n  %s", ErrMismatch, strings.Join(problems, "\n  "))
+}
@@ -0,0 +1,78 @@
+package lock_test
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	. "github.com/shakefu/commonrepo/pkg/lock"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestLock(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Lock", func() {
+		var locked *Lock
+
+		g.BeforeEach(func() {
+			locked = &Lock{Upstream: []Upstream{{
+				URL:      "https://github.com/shakefu/commonrepo",
+				Ref:      "main",
+				Resolved: "refs/heads/main",
+				Commit:   "da24ddd",
+				Files:    map[string]string{"LICENSE": Hash([]byte("license"))},
+			}}}
+		})
+
+		g.It("hashes content", func() {
+			Expect(Hash([]byte(""))).To(Equal(
+				"sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+		})
+
+		g.It("round trips through a filesystem", func() {
+			fs := memfs.New()
+			Expect(locked.Write(fs, FileName)).To(Succeed())
+			read, err := Read(fs, FileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read).To(Equal(locked))
+		})
+
+		g.It("verifies a matching lock", func() {
+			data, err := locked.Marshal()
+			Expect(err).ToNot(HaveOccurred())
+			resolved, err := Parse(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locked.Verify(resolved)).To(Succeed())
+		})
+
+		g.It("reports moved commits", func() {
+			resolved := &Lock{Upstream: []Upstream{locked.Upstream[0]}}
+			resolved.Upstream[0].Commit = "abc1234"
+			err := locked.Verify(resolved)
+			Expect(err).To(MatchError(ErrMismatch))
+			Expect(err.Error()).To(ContainSubstring("locked commit da24ddd, resolved abc1234"))
+		})
+
+		g.It("reports changed and new files", func() {
+			resolved := &Lock{Upstream: []Upstream{locked.Upstream[0]}}
+			resolved.Upstream[0].Files = map[string]string{
+				"LICENSE":   Hash([]byte("changed")),
+				"README.md": Hash([]byte("readme")),
+			}
+			err := locked.Verify(resolved)
+			Expect(err).To(MatchError(ErrMismatch))
+			Expect(err.Error()).To(ContainSubstring("LICENSE content changed"))
+			Expect(err.Error()).To(ContainSubstring("README.md is newly provided"))
+		})
+
+		g.It("reports different upstreams", func() {
+			err := locked.Verify(&Lock{})
+			Expect(err).To(MatchError(ErrMismatch))
+			Expect(err.Error()).To(ContainSubstring("locked 1 upstreams, resolved 0"))
+		})
+	})
+}
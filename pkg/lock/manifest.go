@@ -0,0 +1,84 @@
+package lock
+
+import (
+	"os"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/goccy/go-yaml"
+)
+
+// ManifestFileName is the name of the manifest, written to the repository root
+const ManifestFileName = ".commonrepo.manifest"
+
+// Manifest records every managed file written to the repository so we can tell
+// when an upstream stops providing one
+type Manifest struct {
+	Files map[string]ManagedFile `yaml:"files"`
+}
+
+// ManagedFile is a single file written by commonrepo
+type ManagedFile struct {
+	Upstream string `yaml:"upstream"`        // The upstream which provided the file
+	Hash     string `yaml:"hash"`            // Hash of the content last written
+	Block    bool   `yaml:"block,omitempty"` // Whether only blocks within the file are managed
+}
+
+// NewManifest returns an empty Manifest
+func NewManifest() *Manifest {
+	return &Manifest{Files: make(map[string]ManagedFile)}
+}
+
+// ParseManifest returns a Manifest from the given yaml data
+func ParseManifest(data []byte) (manifest *Manifest, err error) {
+	manifest = NewManifest()
+	if err = yaml.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]ManagedFile)
+	}
+	return
+}
+
+// ReadManifest returns the Manifest stored at path in the given filesystem, or
+// an empty Manifest if there isn't one yet
+func ReadManifest(fs billy.Filesystem, path string) (manifest *Manifest, err error) {
+	var data []byte
+	if data, err = util.ReadFile(fs, path); err != nil {
+		if os.IsNotExist(err) {
+			return NewManifest(), nil
+		}
+		return
+	}
+	return ParseManifest(data)
+}
+
+// Marshal returns the yaml representation of the Manifest
+func (manifest *Manifest) Marshal() (data []byte, err error) {
+	var body []byte
+	if body, err = yaml.Marshal(manifest); err != nil {
+		return
+	}
+	return append([]byte(header), body...), nil
+}
+
+// Write stores the Manifest at path in the given filesystem
+func (manifest *Manifest) Write(fs billy.Filesystem, path string) (err error) {
+	var data []byte
+	if data, err = manifest.Marshal(); err != nil {
+		return
+	}
+	return util.WriteFile(fs, path, data, 0644)
+}
+
+// Names returns the sorted names of all the managed files
+func (manifest *Manifest) Names() []string {
+	names := make([]string, 0, len(manifest.Files))
+	for name := range manifest.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,43 @@
+package lock_test
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	. "github.com/shakefu/commonrepo/pkg/lock"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestManifest(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Manifest", func() {
+		g.It("is empty when it doesn't exist", func() {
+			manifest, err := ReadManifest(memfs.New(), ManifestFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Files).To(BeEmpty())
+		})
+
+		g.It("round trips through a filesystem", func() {
+			fs := memfs.New()
+			manifest := NewManifest()
+			manifest.Files["b.yml"] = ManagedFile{Upstream: "upstream@main", Hash: Hash([]byte("b"))}
+			manifest.Files["a.yml"] = ManagedFile{Upstream: "upstream@main", Hash: Hash([]byte("a"))}
+			Expect(manifest.Write(fs, ManifestFileName)).To(Succeed())
+			read, err := ReadManifest(fs, ManifestFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read).To(Equal(manifest))
+			Expect(read.Names()).To(Equal([]string{"a.yml", "b.yml"}))
+		})
+
+		g.It("parses an empty manifest", func() {
+			manifest, err := ParseManifest([]byte("# nothing\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Files).ToNot(BeNil())
+		})
+	})
+}
@@ -0,0 +1,180 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnterminatedBlock is returned when a managed block's begin marker has no
+// matching end marker
+var ErrUnterminatedBlock = errors.New("managed block is missing its end marker")
+
+// blockPrefix is what every managed block marker starts with, after the
+// comment characters
+const blockPrefix = "commonrepo:"
+
+// Block is the content an upstream manages inside a local file
+type Block struct {
+	Upstream string // Name of the upstream, used in the markers
+	Content  []byte // Content between the markers
+}
+
+// comment returns the opening and closing comment characters for the file
+func comment(name string) (open string, close string) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown", ".html", ".htm", ".xml", ".svg":
+		return "<!-- ", " -->"
+	case ".go", ".js", ".jsx", ".ts", ".tsx", ".java", ".c", ".h", ".cc", ".cpp",
+		".hpp", ".cs", ".rs", ".swift", ".kt", ".scala", ".groovy", ".gradle":
+		return "// ", ""
+	}
+	return "# ", ""
+}
+
+// Markers returns the begin and end marker lines for the upstream's block in
+// the named file
+func Markers(name string, upstream string) (begin string, end string) {
+	open, close := comment(name)
+	begin = open + "BEGIN " + blockPrefix + upstream + close
+	end = open + "END " + blockPrefix + upstream + close
+	return
+}
+
+// Blocks writes each block between its markers in the existing content of the
+// named file, replacing what was there before, and appends any blocks which
+// aren't there yet. Blocks of other upstreams are removed, since they don't
+// contribute to the file anymore. Everything outside the markers is left
+// untouched.
+func Blocks(name string, existing []byte, blocks ...Block) (content []byte, err error) {
+	combined := combineBlocks(blocks)
+	keep := make(map[string]bool, len(combined))
+	for _, block := range combined {
+		keep[block.Upstream] = true
+	}
+	lines, err := stripBlocks(name, splitLines(string(existing)), keep)
+	if err != nil {
+		return
+	}
+	for _, block := range combined {
+		begin, end := Markers(name, block.Upstream)
+		inner := splitLines(string(block.Content))
+
+		start, stop := findBlock(lines, begin, end)
+		switch {
+		case start >= 0 && stop < 0:
+			return nil, fmt.Errorf("%w: %s in %s", ErrUnterminatedBlock, begin, name)
+		case start >= 0:
+			replaced := make([]string, 0, len(lines)-(stop-start)+len(inner))
+			replaced = append(replaced, lines[:start+1]...)
+			replaced = append(replaced, inner...)
+			lines = append(replaced, lines[stop:]...)
+		default:
+			// Keep new blocks visually separate from what's already there
+			if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+				lines = append(lines, "")
+			}
+			lines = append(lines, begin)
+			lines = append(lines, inner...)
+			lines = append(lines, end)
+		}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// StripBlocks removes every managed block, including the markers, from the
+// content of the named file
+func StripBlocks(name string, content []byte) (stripped []byte, err error) {
+	kept, err := stripBlocks(name, splitLines(string(content)), nil)
+	if err != nil {
+		return
+	}
+	if len(kept) == 0 {
+		return []byte{}, nil
+	}
+	return []byte(strings.Join(kept, "\n") + "\n"), nil
+}
+
+// stripBlocks removes the managed blocks of upstreams which aren't kept from the
+// lines, along with the blank lines separating them
+func stripBlocks(name string, lines []string, keep map[string]bool) (kept []string, err error) {
+	open, close := comment(name)
+	begin := open + "BEGIN " + blockPrefix
+	stripped := false
+	var inside string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if inside != "" {
+			if trimmed == inside {
+				inside = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, begin) && strings.HasSuffix(trimmed, close) {
+			upstream := strings.TrimSuffix(strings.TrimPrefix(trimmed, begin), close)
+			if !keep[upstream] {
+				// Along with the blank line we added to separate it
+				if n := len(kept); n > 0 && strings.TrimSpace(kept[n-1]) == "" {
+					kept = kept[:n-1]
+				}
+				_, inside = Markers(name, upstream)
+				stripped = true
+				continue
+			}
+		}
+		kept = append(kept, line)
+	}
+	if inside != "" {
+		return nil, fmt.Errorf("%w: %s in %s", ErrUnterminatedBlock, inside, name)
+	}
+	// And any left at the end
+	for stripped && len(kept) > 0 && strings.TrimSpace(kept[len(kept)-1]) == "" {
+		kept = kept[:len(kept)-1]
+	}
+	return
+}
+
+// combineBlocks joins the content of blocks from the same upstream, since they
+// share markers, keeping the order each upstream first appears in
+func combineBlocks(blocks []Block) (combined []Block) {
+	index := map[string]int{}
+	for _, block := range blocks {
+		i, ok := index[block.Upstream]
+		if !ok {
+			index[block.Upstream] = len(combined)
+			combined = append(combined, Block{Upstream: block.Upstream, Content: block.Content})
+			continue
+		}
+		content := append([]byte{}, combined[i].Content...)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			content = append(content, '\n')
+		}
+		combined[i].Content = append(content, block.Content...)
+	}
+	return
+}
+
+// findBlock returns the line indexes of the begin and end markers, or -1 if
+// they aren't found
+func findBlock(lines []string, begin string, end string) (start int, stop int) {
+	start, stop = -1, -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if start < 0 && trimmed == begin {
+			start = i
+		} else if start >= 0 && trimmed == end {
+			return start, i
+		}
+	}
+	return
+}
+
+// splitLines splits the text into lines without the final newline
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,124 @@
+package merge_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/commonrepo/pkg/merge"
+	"github.com/shakefu/goblin"
+)
+
+func TestBlocks(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Blocks", func() {
+		lint := merge.Block{Upstream: "github.com/a/lint", Content: []byte("lint:\n\tgolangci-lint run\n")}
+		test := merge.Block{Upstream: "github.com/a/test", Content: []byte("test:\n\tgo test ./...\n")}
+
+		g.It("creates a file of blocks", func() {
+			content, err := merge.Blocks("Makefile", nil, lint, test)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("# BEGIN commonrepo:github.com/a/lint\n" +
+				"lint:\n\tgolangci-lint run\n" +
+				"# END commonrepo:github.com/a/lint\n" +
+				"\n" +
+				"# BEGIN commonrepo:github.com/a/test\n" +
+				"test:\n\tgo test ./...\n" +
+				"# END commonrepo:github.com/a/test\n"))
+		})
+
+		g.It("appends new blocks to the local file", func() {
+			content, err := merge.Blocks("Makefile", []byte("build:\n\tgo build\n"), lint)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("build:\n\tgo build\n" +
+				"\n" +
+				"# BEGIN commonrepo:github.com/a/lint\n" +
+				"lint:\n\tgolangci-lint run\n" +
+				"# END commonrepo:github.com/a/lint\n"))
+		})
+
+		g.It("replaces existing blocks in place", func() {
+			existing := "build:\n\tgo build\n" +
+				"# BEGIN commonrepo:github.com/a/lint\n" +
+				"old:\n" +
+				"# END commonrepo:github.com/a/lint\n" +
+				"release:\n\tgoreleaser\n"
+			content, err := merge.Blocks("Makefile", []byte(existing), lint)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("build:\n\tgo build\n" +
+				"# BEGIN commonrepo:github.com/a/lint\n" +
+				"lint:\n\tgolangci-lint run\n" +
+				"# END commonrepo:github.com/a/lint\n" +
+				"release:\n\tgoreleaser\n"))
+		})
+
+		g.It("removes the blocks of other upstreams", func() {
+			existing := "build:\n\tgo build\n" +
+				"\n" +
+				"# BEGIN commonrepo:github.com/a/gone\n" +
+				"gone:\n" +
+				"# END commonrepo:github.com/a/gone\n" +
+				"\n" +
+				"# BEGIN commonrepo:github.com/a/lint\n" +
+				"old:\n" +
+				"# END commonrepo:github.com/a/lint\n"
+			content, err := merge.Blocks("Makefile", []byte(existing), lint)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("build:\n\tgo build\n" +
+				"\n" +
+				"# BEGIN commonrepo:github.com/a/lint\n" +
+				"lint:\n\tgolangci-lint run\n" +
+				"# END commonrepo:github.com/a/lint\n"))
+
+			content, err = merge.Blocks("Makefile", []byte(existing+"\n# BEGIN commonrepo:github.com/a/gone\n"), lint)
+			Expect(err).To(MatchError(merge.ErrUnterminatedBlock))
+		})
+
+		g.It("is stable", func() {
+			first, err := merge.Blocks("Makefile", []byte("build:\n"), lint, test)
+			Expect(err).ToNot(HaveOccurred())
+			second, err := merge.Blocks("Makefile", first, lint, test)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(second)).To(Equal(string(first)))
+		})
+
+		g.It("combines blocks from the same upstream", func() {
+			content, err := merge.Blocks("Makefile", nil, lint, merge.Block{Upstream: lint.Upstream, Content: []byte("fmt:")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("# BEGIN commonrepo:github.com/a/lint\n" +
+				"lint:\n\tgolangci-lint run\nfmt:\n" +
+				"# END commonrepo:github.com/a/lint\n"))
+		})
+
+		g.It("uses the comment style of the file", func() {
+			begin, end := merge.Markers("README.md", "github.com/a/badges")
+			Expect(begin).To(Equal("<!-- BEGIN commonrepo:github.com/a/badges -->"))
+			Expect(end).To(Equal("<!-- END commonrepo:github.com/a/badges -->"))
+			begin, _ = merge.Markers("main.go", "x")
+			Expect(begin).To(Equal("// BEGIN commonrepo:x"))
+			begin, _ = merge.Markers(".envrc", "x")
+			Expect(begin).To(Equal("# BEGIN commonrepo:x"))
+		})
+
+		g.It("errors without an end marker", func() {
+			_, err := merge.Blocks("Makefile", []byte("# BEGIN commonrepo:github.com/a/lint\nold:\n"), lint)
+			Expect(err).To(MatchError(merge.ErrUnterminatedBlock))
+		})
+	})
+
+	g.Describe("StripBlocks", func() {
+		g.It("removes every block", func() {
+			content := "<!-- BEGIN commonrepo:x -->\n[badge]\n<!-- END commonrepo:x -->\n# Title\n\n" +
+				"<!-- BEGIN commonrepo:y -->\nfooter\n<!-- END commonrepo:y -->\n"
+			stripped, err := merge.StripBlocks("README.md", []byte(content))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(stripped)).To(Equal("# Title\n"))
+		})
+
+		g.It("errors without an end marker", func() {
+			_, err := merge.StripBlocks("Makefile", []byte("# BEGIN commonrepo:x\n"))
+			Expect(err).To(MatchError(merge.ErrUnterminatedBlock))
+		})
+	})
+}
@@ -0,0 +1,188 @@
+package merge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/BurntSushi/toml"
+	"github.com/goccy/go-yaml"
+)
+
+// yamlCodec keeps the key order of mappings, but not comments
+type yamlCodec struct{}
+
+func (yamlCodec) decode(data []byte) (doc interface{}, err error) {
+	err = yaml.UnmarshalWithOptions(data, &doc, yaml.UseOrderedMap())
+	return
+}
+
+func (yamlCodec) encode(doc interface{}) ([]byte, error) {
+	return yaml.MarshalWithOptions(doc, yaml.IndentSequence(true))
+}
+
+// jsonCodec keeps the key order of objects and the exact text of numbers
+type jsonCodec struct{}
+
+func (jsonCodec) decode(data []byte) (doc interface{}, err error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if doc, err = decodeJSON(decoder); err != nil {
+		return nil, err
+	}
+	// Make sure there's nothing trailing the document
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after top-level value")
+	}
+	return doc, nil
+}
+
+// decodeJSON decodes the next value from the decoder, using ordered mappings
+// for objects
+func decodeJSON(decoder *json.Decoder) (value interface{}, err error) {
+	var token json.Token
+	if token, err = decoder.Token(); err != nil {
+		return
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := yaml.MapSlice{}
+		for decoder.More() {
+			if token, err = decoder.Token(); err != nil {
+				return
+			}
+			var item interface{}
+			if item, err = decodeJSON(decoder); err != nil {
+				return
+			}
+			object = append(object, yaml.MapItem{Key: token, Value: item})
+		}
+		// Consume the closing delimiter
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for decoder.More() {
+			var item interface{}
+			if item, err = decodeJSON(decoder); err != nil {
+				return
+			}
+			list = append(list, item)
+		}
+		_, err = decoder.Token()
+		return list, err
+	}
+	return token, nil
+}
+
+func (jsonCodec) encode(doc interface{}) (data []byte, err error) {
+	var compact bytes.Buffer
+	if err = encodeJSON(&compact, doc); err != nil {
+		return
+	}
+	var indented bytes.Buffer
+	if err = json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+		return
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
+}
+
+// encodeJSON writes the compact JSON for value, keeping the order of ordered
+// mappings
+func encodeJSON(buf *bytes.Buffer, value interface{}) (err error) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		buf.WriteByte('{')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err = encodeJSON(buf, fmt.Sprint(item.Key)); err != nil {
+				return
+			}
+			buf.WriteByte(':')
+			if err = encodeJSON(buf, item.Value); err != nil {
+				return
+			}
+		}
+		buf.WriteByte('}')
+		return
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err = encodeJSON(buf, item); err != nil {
+				return
+			}
+		}
+		buf.WriteByte(']')
+		return
+	}
+
+	// Leave HTML characters alone, these aren't going in a web page
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(value); err != nil {
+		return
+	}
+	// Drop the newline the encoder adds
+	buf.Truncate(buf.Len() - 1)
+	return
+}
+
+// tomlCodec sorts the keys of every table when encoding
+type tomlCodec struct{}
+
+func (tomlCodec) decode(data []byte) (doc interface{}, err error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	table := map[string]interface{}{}
+	if err = toml.Unmarshal(data, &table); err != nil {
+		return
+	}
+	return normalizeTOML(table), nil
+}
+
+// normalizeTOML converts arrays of tables into plain lists so they merge like
+// any other list
+func normalizeTOML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeTOML(item)
+		}
+		return v
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = normalizeTOML(item)
+		}
+		return list
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeTOML(item)
+		}
+		return v
+	}
+	return value
+}
+
+func (tomlCodec) encode(doc interface{}) (data []byte, err error) {
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
+	encoder.Indent = ""
+	if err = encoder.Encode(doc); err != nil {
+		return
+	}
+	return buf.Bytes(), nil
+}
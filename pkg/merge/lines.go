@@ -0,0 +1,117 @@
+package merge
+
+import (
+	"strings"
+)
+
+// lineGroup is a run of comment lines followed by the entries they describe
+type lineGroup struct {
+	comments []string
+	entries  []string
+}
+
+// Lines unions the lines of every layer in order, dropping entries which an
+// earlier layer already has. Blank line separated sections keep their
+// comments, unless none of their entries are new.
+func Lines(layers ...[]byte) []byte {
+	seen := map[string]bool{}
+	emitted := map[string]bool{}
+	sections := []string{}
+
+	for _, layer := range layers {
+		for _, block := range splitBlocks(string(layer)) {
+			groups := groupLines(block)
+
+			var out []string
+			contributes := false
+			for _, group := range groups {
+				var fresh []string
+				for _, entry := range group.entries {
+					key := strings.TrimSpace(entry)
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
+					fresh = append(fresh, entry)
+				}
+				// A comment with nothing new under it would be orphaned, but
+				// trailing comments stay with their section
+				if len(fresh) > 0 || len(group.entries) == 0 {
+					out = append(out, group.comments...)
+					out = append(out, fresh...)
+				}
+				contributes = contributes || len(fresh) > 0
+			}
+
+			section := strings.Join(out, "\n")
+			if len(out) == 0 || emitted[section] {
+				continue
+			}
+			// Comment only sections are kept once, so a shared header isn't
+			// repeated every run
+			if !contributes && !isCommentOnly(groups) {
+				continue
+			}
+			emitted[section] = true
+			sections = append(sections, section)
+		}
+	}
+
+	if len(sections) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(sections, "\n\n") + "\n")
+}
+
+// splitBlocks splits the text into blocks of lines separated by blank lines
+func splitBlocks(text string) (blocks [][]string) {
+	var block []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+			}
+			block = nil
+			continue
+		}
+		block = append(block, line)
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+	return
+}
+
+// groupLines splits a block into its comment and entry groups
+func groupLines(block []string) (groups []lineGroup) {
+	var group lineGroup
+	for _, line := range block {
+		if isComment(line) {
+			// A comment after entries starts a new group
+			if len(group.entries) > 0 {
+				groups = append(groups, group)
+				group = lineGroup{}
+			}
+			group.comments = append(group.comments, line)
+			continue
+		}
+		group.entries = append(group.entries, line)
+	}
+	return append(groups, group)
+}
+
+// isComment returns true if the line is a comment
+func isComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// isCommentOnly returns true if none of the groups have any entries
+func isCommentOnly(groups []lineGroup) bool {
+	for _, group := range groups {
+		if len(group.entries) > 0 {
+			return false
+		}
+	}
+	return true
+}
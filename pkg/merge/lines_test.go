@@ -0,0 +1,97 @@
+package merge_test
+
+import (
+	"testing"
+
+	. "github.com/shakefu/commonrepo/internal/testutil"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/merge"
+	"github.com/shakefu/goblin"
+)
+
+func TestLines(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Lines", func() {
+		golang := InlineYaml(`
+		# Go
+		bin/
+		*.test
+
+		# Editors
+		.idea/
+		.vscode/`)
+		python := InlineYaml(`
+		# Python
+		__pycache__/
+		*.pyc
+
+		# Editors
+		.idea/
+		.vscode/`)
+
+		g.It("unions every layer", func() {
+			Expect(string(merge.Lines(golang, python))).To(Equal(string(InlineYaml(`
+			# Go
+			bin/
+			*.test
+
+			# Editors
+			.idea/
+			.vscode/
+
+			# Python
+			__pycache__/
+			*.pyc
+			`))))
+		})
+
+		g.It("keeps comments for new entries", func() {
+			local := InlineYaml(`
+			# Editors
+			.idea/
+			*.swp`)
+			Expect(string(merge.Lines(local, golang))).To(Equal(string(InlineYaml(`
+			# Editors
+			.idea/
+			*.swp
+
+			# Go
+			bin/
+			*.test
+
+			# Editors
+			.vscode/
+			`))))
+		})
+
+		g.It("keeps comment only sections once", func() {
+			header := []byte("# Managed by commonrepo\n\nbin/\n")
+			Expect(string(merge.Lines(header, header))).To(Equal("# Managed by commonrepo\n\nbin/\n"))
+		})
+
+		g.It("is stable when merged again", func() {
+			merged := merge.Lines(golang, python)
+			Expect(string(merge.Lines(merged, golang, python))).To(Equal(string(merged)))
+		})
+
+		g.It("ignores blank layers", func() {
+			Expect(merge.Lines([]byte("\n\n"), nil)).To(BeEmpty())
+		})
+
+		g.It("is detected for ignore files", func() {
+			for _, name := range []string{".gitignore", "sub/.dockerignore", ".gitattributes", ".github/CODEOWNERS"} {
+				Expect(merge.Detect(name)).To(Equal(config.MergeLines), name)
+			}
+		})
+
+		g.It("always includes the local file", func() {
+			Expect(merge.IncludesLocal(config.MergeRule{Glob: "**"}, ".gitignore")).To(BeTrue())
+			Expect(merge.IncludesLocal(config.MergeRule{Glob: "**"}, "a.yml")).To(BeFalse())
+			Expect(merge.IncludesLocal(config.MergeRule{Glob: "**", Local: true}, "a.yml")).To(BeTrue())
+		})
+	})
+}
@@ -0,0 +1,202 @@
+// Package merge combines the layers of a target which is contributed by more
+// than one upstream into a single file
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/shakefu/commonrepo/pkg/config"
+)
+
+// ErrUnknownStrategy is returned for a file whose merge strategy can't be
+// detected from its name, or a strategy Merge can't apply
+var ErrUnknownStrategy = errors.New("unable to detect merge strategy")
+
+// codec decodes and encodes the documents for a strategy
+type codec interface {
+	decode(data []byte) (interface{}, error)
+	encode(doc interface{}) ([]byte, error)
+}
+
+// Detect returns the merge strategy for the file name based on its extension,
+// or its name for the ignore style files
+func Detect(name string) (strategy config.MergeStrategy, err error) {
+	base := filepath.Base(name)
+	switch {
+	case strings.HasPrefix(base, ".") && strings.HasSuffix(base, "ignore"),
+		base == ".gitattributes", base == "CODEOWNERS":
+		return config.MergeLines, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yml", ".yaml":
+		return config.MergeYAML, nil
+	case ".json":
+		return config.MergeJSON, nil
+	case ".toml":
+		return config.MergeTOML, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownStrategy, name)
+}
+
+// StrategyFor returns the rule's strategy, detecting it from the name if it
+// isn't set
+func StrategyFor(rule config.MergeRule, name string) (config.MergeStrategy, error) {
+	if rule.Strategy == config.MergeAuto {
+		return Detect(name)
+	}
+	return rule.Strategy, nil
+}
+
+// IncludesLocal returns true if the existing local file should be the first
+// layer when merging the named file, which is always the case for lines and
+// blocks
+func IncludesLocal(rule config.MergeRule, name string) bool {
+	strategy, err := StrategyFor(rule, name)
+	if err != nil {
+		return rule.Local
+	}
+	return rule.Local || strategy == config.MergeLines || strategy == config.MergeBlock
+}
+
+// Merge combines the layers of the named file in order using the rule, with
+// the later layers taking precedence
+func Merge(rule config.MergeRule, name string, layers ...[]byte) (merged []byte, err error) {
+	var strategy config.MergeStrategy
+	if strategy, err = StrategyFor(rule, name); err != nil {
+		return
+	}
+
+	var format codec
+	switch strategy {
+	case config.MergeLines:
+		return Lines(layers...), nil
+	case config.MergeBlock:
+		// Blocks need to know which upstream each layer came from
+		return nil, fmt.Errorf("%w: %s needs Blocks", ErrUnknownStrategy, strategy)
+	case config.MergeYAML:
+		format = yamlCodec{}
+	case config.MergeJSON:
+		format = jsonCodec{}
+	case config.MergeTOML:
+		format = tomlCodec{}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, strategy)
+	}
+
+	var result interface{}
+	for i, layer := range layers {
+		var doc interface{}
+		if doc, err = format.decode(layer); err != nil {
+			return nil, fmt.Errorf("%s: layer %d: %w", name, i, err)
+		}
+		// Empty documents don't contribute anything
+		if doc == nil {
+			continue
+		}
+		if result == nil {
+			result = doc
+			continue
+		}
+		result = Values(result, doc, rule.Lists)
+	}
+	if result == nil {
+		return []byte{}, nil
+	}
+	return format.encode(result)
+}
+
+// Values deep merges over onto base and returns the result. Mappings are merged
+// key by key, lists are combined according to lists, and anything else is
+// replaced by over.
+//
+// Neither base nor over are modified.
+func Values(base interface{}, over interface{}, lists config.MergeLists) interface{} {
+	switch o := over.(type) {
+	case yaml.MapSlice:
+		if b, ok := base.(yaml.MapSlice); ok {
+			return mergeMapSlice(b, o, lists)
+		}
+	case map[string]interface{}:
+		if b, ok := base.(map[string]interface{}); ok {
+			return mergeMap(b, o, lists)
+		}
+	case []interface{}:
+		if b, ok := base.([]interface{}); ok {
+			return mergeList(b, o, lists)
+		}
+	}
+	return over
+}
+
+// mergeMapSlice merges ordered mappings, keeping the base order and appending
+// any new keys
+func mergeMapSlice(base yaml.MapSlice, over yaml.MapSlice, lists config.MergeLists) yaml.MapSlice {
+	merged := make(yaml.MapSlice, len(base), len(base)+len(over))
+	copy(merged, base)
+	for _, item := range over {
+		found := false
+		for i := range merged {
+			// Keys aren't always comparable, e.g. complex YAML keys
+			if reflect.DeepEqual(merged[i].Key, item.Key) {
+				merged[i].Value = Values(merged[i].Value, item.Value, lists)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// mergeMap merges unordered mappings
+func mergeMap(base map[string]interface{}, over map[string]interface{}, lists config.MergeLists) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(over))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range over {
+		if existing, ok := merged[key]; ok {
+			value = Values(existing, value, lists)
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// mergeList combines two lists
+func mergeList(base []interface{}, over []interface{}, lists config.MergeLists) []interface{} {
+	switch lists {
+	case config.ListsAppend:
+		merged := make([]interface{}, 0, len(base)+len(over))
+		merged = append(merged, base...)
+		return append(merged, over...)
+	case config.ListsUnique:
+		merged := make([]interface{}, 0, len(base)+len(over))
+		merged = append(merged, base...)
+		for _, value := range over {
+			if !contains(merged, value) {
+				merged = append(merged, value)
+			}
+		}
+		return merged
+	}
+	return over
+}
+
+// contains returns true if the list has an item deeply equal to value
+func contains(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
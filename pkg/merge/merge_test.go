@@ -0,0 +1,176 @@
+package merge_test
+
+import (
+	"testing"
+
+	. "github.com/shakefu/commonrepo/internal/testutil"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/merge"
+	"github.com/shakefu/goblin"
+)
+
+func TestMerge(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Merge", func() {
+		rule := func(lists config.MergeLists) config.MergeRule {
+			return config.MergeRule{Glob: "**", Lists: lists}
+		}
+
+		g.Describe("Detect", func() {
+			g.It("works", func() {
+				Expect(merge.Detect(".golangci.yml")).To(Equal(config.MergeYAML))
+				Expect(merge.Detect("config/app.YAML")).To(Equal(config.MergeYAML))
+				Expect(merge.Detect("renovate.json")).To(Equal(config.MergeJSON))
+				Expect(merge.Detect("pyproject.toml")).To(Equal(config.MergeTOML))
+			})
+
+			g.It("errors for unknown extensions", func() {
+				_, err := merge.Detect("Makefile")
+				Expect(err).To(MatchError(merge.ErrUnknownStrategy))
+			})
+		})
+
+		g.Describe("yaml", func() {
+			base := InlineYaml(`
+			run:
+			  timeout: 5m
+			linters:
+			  enable:
+			    - errcheck
+			    - govet`)
+			over := InlineYaml(`
+			linters:
+			  enable:
+			    - govet
+			    - revive
+			issues:
+			  max-same: 3`)
+
+			g.It("merges keys in order", func() {
+				merged, err := merge.Merge(rule(config.ListsReplace), "a.yml", base, over)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(merged)).To(Equal(string(InlineYaml(`
+				run:
+				  timeout: 5m
+				linters:
+				  enable:
+				    - govet
+				    - revive
+				issues:
+				  max-same: 3
+				`))))
+			})
+
+			g.It("appends lists", func() {
+				merged, err := merge.Merge(rule(config.ListsAppend), "a.yml", base, over)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(merged)).To(ContainSubstring(
+					"    - errcheck\n    - govet\n    - govet\n    - revive\n"))
+			})
+
+			g.It("unions lists", func() {
+				merged, err := merge.Merge(rule(config.ListsUnique), "a.yml", base, over)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(merged)).To(ContainSubstring(
+					"    - errcheck\n    - govet\n    - revive\n"))
+			})
+
+			g.It("replaces mismatched types", func() {
+				merged, err := merge.Merge(rule(config.ListsUnique), "a.yml",
+					[]byte("run: {timeout: 5m}\n"), []byte("run: false\n"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(merged)).To(Equal("run: false\n"))
+			})
+
+			g.It("ignores empty layers", func() {
+				merged, err := merge.Merge(rule(config.ListsReplace), "a.yml", []byte{}, base, []byte("\n"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(merged)).To(HavePrefix("run:\n  timeout: 5m\n"))
+			})
+
+			g.It("errors on invalid layers", func() {
+				_, err := merge.Merge(rule(config.ListsReplace), "a.yml", base, []byte("{a: 1"))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(HavePrefix("a.yml: layer 1: "))
+			})
+		})
+
+		g.Describe("json", func() {
+			g.It("merges keys in order", func() {
+				merged, err := merge.Merge(rule(config.ListsUnique), "renovate.json",
+					[]byte(`{"extends": ["config:base"], "schedule": ["weekly"], "prHourlyLimit": 2}`),
+					[]byte(`{"extends": ["config:base", ":semanticCommits"], "prHourlyLimit": 0.5, "labels": ["<deps>"]}`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(merged)).To(Equal(`{
+  "extends": [
+    "config:base",
+    ":semanticCommits"
+  ],
+  "schedule": [
+    "weekly"
+  ],
+  "prHourlyLimit": 0.5,
+  "labels": [
+    "<deps>"
+  ]
+}
+`))
+			})
+
+			g.It("errors on trailing data", func() {
+				_, err := merge.Merge(rule(config.ListsReplace), "a.json", []byte(`{} {}`))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		g.Describe("toml", func() {
+			g.It("merges tables", func() {
+				merged, err := merge.Merge(rule(config.ListsUnique), "pyproject.toml",
+					InlineYaml(`
+					[tool.black]
+					line-length = 88
+
+					[tool.isort]
+					profile = "black"
+					known_first_party = ["app"]`),
+					InlineYaml(`
+					[tool.black]
+					line-length = 100
+
+					[tool.isort]
+					known_first_party = ["lib"]`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(merged)).To(Equal(string(InlineYaml(`
+				[tool]
+				[tool.black]
+				line-length = 100
+				[tool.isort]
+				known_first_party = ["app", "lib"]
+				profile = "black"
+				`))))
+			})
+
+			g.It("merges arrays of tables as lists", func() {
+				merged, err := merge.Merge(rule(config.ListsAppend), "a.toml",
+					[]byte("[[plugin]]\nname = \"a\"\n"),
+					[]byte("[[plugin]]\nname = \"b\"\n"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(merged)).To(Equal("[[plugin]]\nname = \"a\"\n\n[[plugin]]\nname = \"b\"\n"))
+			})
+		})
+
+		g.Describe("Values", func() {
+			g.It("doesn't modify its arguments", func() {
+				base := map[string]interface{}{"a": []interface{}{1}}
+				over := map[string]interface{}{"a": []interface{}{2}, "b": 3}
+				merged := merge.Values(base, over, config.ListsAppend)
+				Expect(merged).To(Equal(map[string]interface{}{"a": []interface{}{1, 2}, "b": 3}))
+				Expect(base).To(Equal(map[string]interface{}{"a": []interface{}{1}}))
+			})
+		})
+	})
+}
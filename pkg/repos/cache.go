@@ -0,0 +1,371 @@
+package repos
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shakefu/commonrepo/pkg/common"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// advertised holds the refs each remote advertised, so repeated upstreams in
+// a run only ask once
+var advertised sync.Map
+
+// Waiting for a cache directory's lock checks it every cacheLockWait, and a lock
+// older than cacheLockStale was left by a run which died
+const (
+	cacheLockWait  = 50 * time.Millisecond
+	cacheLockStale = 10 * time.Minute
+)
+
+// advertisedRefs returns the refs the remote advertises, asking it only the
+// first time in a run
+func advertisedRefs(ctx context.Context, url string) (refs memory.ReferenceStorage, err error) {
+	if found, ok := advertised.Load(url); ok {
+		return found.(memory.ReferenceStorage), nil
+	}
+	if refs, err = gitutil.GetRefsContext(ctx, url); err != nil {
+		return
+	}
+	advertised.Store(url, refs)
+	return
+}
+
+// CachePath returns the directory in the cache root which holds the objects
+// fetched from the URL. It's named after the repository with a hash of the
+// normalized URL, so different spellings of the same remote share it.
+func CachePath(root string, url string) string {
+	normal := gitutil.NormalizeURL(url)
+	sum := sha256.Sum256([]byte(normal))
+	name := strings.TrimSuffix(path.Base(filepath.ToSlash(normal)), ".git")
+	return filepath.Join(root, name+"-"+hex.EncodeToString(sum[:8]))
+}
+
+// cacheDir returns the repo's directory in the cache, or an empty string if
+// it isn't cached. Repositories on this machine are read directly instead.
+func (repo *Repo) cacheDir() string {
+	root := common.CacheDir()
+	if root == "" || gitutil.IsLocal(repo.url) {
+		return ""
+	}
+	return CachePath(root, repo.URL)
+}
+
+// openCache locks the cache directory and returns its storage, with the
+// function which closes the storage and releases the lock
+func openCache(ctx context.Context, dir string) (store *filesystem.Storage, done func(), err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	unlock, err := lockCache(ctx, dir)
+	if err != nil {
+		return
+	}
+	store = filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+	done = func() {
+		store.Close()
+		unlock()
+	}
+	return
+}
+
+// lockCache takes the lock file next to the cache directory, waiting while
+// another run holds it, since hooks and CI jobs in many repositories can share
+// the cache at once. It returns the function which releases the lock.
+func lockCache(ctx context.Context, dir string) (unlock func(), err error) {
+	path := dir + ".lock"
+	for {
+		var file *os.File
+		if file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return
+		}
+		// Nothing would ever release a lock left by a run which died
+		if info, statErr := os.Stat(path); statErr == nil && time.Since(info.ModTime()) > cacheLockStale {
+			os.Remove(path)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for %s: %w", path, ctx.Err())
+		case <-time.After(cacheLockWait):
+		}
+	}
+}
+
+// cloneCached fetches the ref into the cache, unless the commit it points to
+// is already there, then checks the commit out into the memory filesystem.
+func (repo *Repo) cloneCached(ctx context.Context, dir string) (err error) {
+	store, done, err := openCache(ctx, dir)
+	if err != nil {
+		return
+	}
+	defer done()
+	return repo.cloneStore(ctx, store)
+}
+
+// cachedCommit returns true if the ref is a full commit SHA which is already in
+// the cache, so the remote doesn't need to be asked about it
+func (repo *Repo) cachedCommit(ctx context.Context, refname string) bool {
+	dir := repo.cacheDir()
+	if dir == "" || len(refname) != len(plumbing.ZeroHash.String()) || !gitutil.IsHash(refname) {
+		return false
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return false
+	}
+	store, done, err := openCache(ctx, dir)
+	if err != nil {
+		return false
+	}
+	defer done()
+	_, err = store.EncodedObject(plumbing.CommitObject, plumbing.NewHash(refname))
+	return err == nil
+}
+
+// cloneStore fetches the ref or pinned commit into the store, unless it's
+// already there, then checks the commit out into the memory filesystem.
+func (repo *Repo) cloneStore(ctx context.Context, store storage.Storer) (err error) {
+	var hash plumbing.Hash
+	if repo.pinned {
+		hash, err = repo.fetchCommit(ctx, store)
+	} else {
+		hash, err = repo.fetchRef(ctx, store)
+	}
+	if err != nil {
+		return
+	}
+
+	var commit *object.Commit
+	if commit, err = peelCommit(store, hash); err != nil {
+		return
+	}
+	repo.commit = commit.Hash
+	return repo.checkout(commit)
+}
+
+// fetchRef returns what the ref points to, fetching it into the store if the
+// remote's commit isn't there
+func (repo *Repo) fetchRef(ctx context.Context, store storage.Storer) (hash plumbing.Hash, err error) {
+	// Only go to the network when we don't already have what the ref points at
+	if repo.advertised.IsZero() || store.HasEncodedObject(repo.advertised) != nil {
+		if repo.offline() {
+			return hash, fmt.Errorf("%w: %s isn't cached", gitutil.ErrOffline, repo)
+		}
+		refspec := config.RefSpec("+" + repo.ref.String() + ":" + repo.ref.String())
+		if err = repo.fetch(ctx, store, 1, refspec); err != nil {
+			return
+		}
+		// Remember the default branch so it can be found offline
+		if repo.requested == "" {
+			head := plumbing.NewSymbolicReference(plumbing.HEAD, repo.ref)
+			if err = store.SetReference(head); err != nil {
+				return
+			}
+		}
+	}
+
+	if hash = repo.advertised; hash.IsZero() {
+		var ref *plumbing.Reference
+		if ref, err = store.Reference(repo.ref); err != nil {
+			return
+		}
+		hash = ref.Hash()
+	}
+	return
+}
+
+// fetchCommit returns the pinned commit, fetching it into the store if it
+// isn't there. Abbreviated hashes, and remotes which won't send a commit by
+// its hash, need the history of every branch and tag fetched to find it.
+func (repo *Repo) fetchCommit(ctx context.Context, store storage.Storer) (hash plumbing.Hash, err error) {
+	if hash, err = findCommit(store, repo.ref.String()); err != nil || !hash.IsZero() {
+		return repo.pin(hash), err
+	}
+	if repo.offline() {
+		return hash, fmt.Errorf("%w: %s isn't cached", gitutil.ErrOffline, repo)
+	}
+
+	if !repo.advertised.IsZero() {
+		sha := repo.advertised.String()
+		err = repo.fetch(ctx, store, 1, config.RefSpec(sha+":refs/pinned/"+sha))
+	}
+	if repo.advertised.IsZero() || err != nil && ctx.Err() == nil {
+		err = repo.fetchHistory(ctx, store, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	}
+	if err != nil {
+		return
+	}
+
+	if hash, err = findCommit(store, repo.ref.String()); err == nil && hash.IsZero() {
+		err = fmt.Errorf("%w: no commit %s in %s", gitutil.ErrUnknownRef, repo.ref, repo.URL)
+	}
+	return repo.pin(hash), err
+}
+
+// pin records the full hash of the pinned commit, returning it
+func (repo *Repo) pin(hash plumbing.Hash) plumbing.Hash {
+	if !hash.IsZero() {
+		repo.ref = plumbing.ReferenceName(hash.String())
+		repo.advertised = hash
+	}
+	return hash
+}
+
+// fetch fetches the refspecs from the repo's remote into the store, only depth
+// commits deep unless it's 0
+func (repo *Repo) fetch(ctx context.Context, store storage.Storer, depth int, refspecs ...config.RefSpec) (err error) {
+	// The remote is never saved, so credentials from insteadOf rules don't
+	// end up on disk
+	remote := git.NewRemote(store, &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repo.url},
+	})
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: refspecs,
+		Depth:    depth,
+		Tags:     git.NoTags,
+		Force:    true,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		err = nil
+	}
+	return
+}
+
+// fetchHistory fetches the refspecs with all of their history, deepening the
+// store if earlier fetches were shallow
+func (repo *Repo) fetchHistory(ctx context.Context, store storage.Storer, refspecs ...config.RefSpec) (err error) {
+	shallow, err := store.Shallow()
+	if err != nil {
+		return
+	}
+	if len(shallow) == 0 {
+		return repo.fetch(ctx, store, 0, refspecs...)
+	}
+	// This is how git fetch --unshallow asks for everything
+	if err = repo.fetch(ctx, store, math.MaxInt32, refspecs...); err != nil {
+		return
+	}
+
+	// go-git never removes shallow commits, so keep only the ones which are
+	// still missing a parent
+	var still []plumbing.Hash
+	for _, hash := range shallow {
+		var commit *object.Commit
+		if commit, err = object.GetCommit(store, hash); err != nil {
+			return
+		}
+		for _, parent := range commit.ParentHashes {
+			if store.HasEncodedObject(parent) != nil {
+				still = append(still, hash)
+				break
+			}
+		}
+	}
+	return store.SetShallow(still)
+}
+
+// findCommit returns the commit in the store whose hash starts with prefix,
+// or the zero hash if there isn't one
+func findCommit(store storer.EncodedObjectStorer, prefix string) (hash plumbing.Hash, err error) {
+	if len(prefix) == len(plumbing.ZeroHash.String()) {
+		if full := plumbing.NewHash(prefix); store.HasEncodedObject(full) == nil {
+			hash = full
+		}
+		return
+	}
+
+	iter, err := store.IterEncodedObjects(plumbing.CommitObject)
+	if err != nil {
+		return
+	}
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		if !strings.HasPrefix(obj.Hash().String(), prefix) || obj.Hash() == hash {
+			return nil
+		}
+		if !hash.IsZero() {
+			return fmt.Errorf("%w: %q matches more than one commit", gitutil.ErrAmbiguousRef, prefix)
+		}
+		hash = obj.Hash()
+		return nil
+	})
+	return
+}
+
+// peelCommit returns the commit the hash refers to, following annotated tags
+func peelCommit(store storer.EncodedObjectStorer, hash plumbing.Hash) (commit *object.Commit, err error) {
+	var obj object.Object
+	if obj, err = object.GetObject(store, hash); err != nil {
+		return
+	}
+	switch found := obj.(type) {
+	case *object.Commit:
+		return found, nil
+	case *object.Tag:
+		return found.Commit()
+	}
+	return nil, object.ErrUnsupportedObject
+}
+
+// checkout writes every file in the commit to the memory filesystem
+func (repo *Repo) checkout(commit *object.Commit) (err error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return
+	}
+	return tree.Files().ForEach(func(file *object.File) (err error) {
+		if file.Mode == filemode.Symlink {
+			var target string
+			if target, err = file.Contents(); err != nil {
+				return
+			}
+			return repo.fs.Symlink(target, file.Name)
+		}
+
+		mode, err := file.Mode.ToOSFileMode()
+		if err != nil {
+			return
+		}
+		reader, err := file.Reader()
+		if err != nil {
+			return
+		}
+		defer reader.Close()
+
+		handle, err := repo.fs.OpenFile(file.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+		if err != nil {
+			return
+		}
+		defer handle.Close()
+		_, err = io.Copy(handle, reader)
+		return
+	})
+}
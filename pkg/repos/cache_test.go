@@ -0,0 +1,49 @@
+package repos_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/shakefu/commonrepo/pkg/common"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+	. "github.com/shakefu/commonrepo/pkg/repos"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestCache(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("cache", func() {
+		var root string
+		var path string
+
+		g.Before(func() {
+			var err error
+			root = common.CacheDir()
+			if path, err = gitutil.FindLocalRepoPath(); err != nil {
+				g.FailNow()
+			}
+		})
+
+		g.Describe("CachePath", func() {
+			g.It("is the same for every spelling of a remote", func() {
+				Expect(CachePath(root, "https://github.com/shakefu/commonrepo")).To(Equal(
+					CachePath(root, "git@github.com:shakefu/commonrepo.git")))
+				Expect(filepath.Base(CachePath(root, "https://github.com/shakefu/commonrepo"))).To(
+					MatchRegexp(`^commonrepo-[0-9a-f]{16}$`))
+			})
+		})
+
+		g.Describe("Clone", func() {
+			g.It("doesn't cache repositories on this machine", func() {
+				_, err := New(path)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(CachePath(root, path)).ToNot(BeADirectory())
+			})
+		})
+	})
+}
@@ -0,0 +1,65 @@
+package repos
+
+import (
+	"path"
+
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+)
+
+// TemplateContext is what every template gets as .CommonRepo, describing where
+// it's from and where it's going. The fields of the template's upstream can be
+// used directly, like .CommonRepo.Resolved.
+type TemplateContext struct {
+	TemplateUpstream                    // The upstream the template is from
+	Source           string             // Original path of the template
+	Destination      string             // Path the template is written to
+	Downstream       string             // Name of the repository written to
+	DefaultBranch    string             // Default branch of the repository written to
+	Upstreams        []TemplateUpstream // Every upstream, in the order they're applied
+}
+
+// TemplateUpstream describes an upstream to templates
+type TemplateUpstream struct {
+	Name     string // Last part of the URL
+	URL      string // URL in the config
+	Ref      string // Ref in the config, or the default branch
+	Resolved string // Branch or tag the ref resolved to, or the commit
+	Commit   string // Full hash of the cloned commit
+}
+
+// TemplateUpstream returns the repo as templates see it
+func (repo *Repo) TemplateUpstream() TemplateUpstream {
+	return TemplateUpstream{
+		Name:     path.Base(gitutil.NormalizeURL(repo.URL)),
+		URL:      repo.URL,
+		Ref:      repo.Ref,
+		Resolved: repo.ref.Short(),
+		Commit:   repo.commit.String(),
+	}
+}
+
+// ApplyTemplateContext gives every template target the context, with the
+// upstream, source and destination filled in, as config.ContextVar. Call it
+// after the renames so the destinations are final.
+//
+// Templates with empty vars get it too, so they're rendered rather than copied
+// as is, and a {{ in one which isn't an action needs a raw region or Delims.
+func (repo *Repo) ApplyTemplateContext(meta TemplateContext) {
+	meta.TemplateUpstream = repo.TemplateUpstream()
+	for destination, target := range repo.Targets() {
+		// Only templates have vars, even if they're empty
+		if target.Vars == nil {
+			continue
+		}
+		vars := make(map[string]interface{}, len(target.Vars)+1)
+		for k, v := range target.Vars {
+			vars[k] = v
+		}
+		meta.Source = target.Name
+		meta.Destination = destination
+		vars[config.ContextVar] = meta
+		target.Vars = vars
+		repo.targets[destination] = target
+	}
+}
@@ -0,0 +1,64 @@
+package repos_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/shakefu/commonrepo/pkg/config"
+	. "github.com/shakefu/commonrepo/pkg/repos"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestContext(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("ApplyTemplateContext", func() {
+		var repo *Repo
+		var err error
+		var vars = map[string]interface{}{"templated": true}
+		var workflow = "testdata/fixtures/templates/workflow.yml"
+
+		g.Before(func() {
+			if repo, err = GetLocalRepo(); err != nil {
+				g.FailNow()
+			}
+			Expect(repo.ApplyTemplates([]string{"testdata/fixtures/local/deep.yml"}, vars)).To(Succeed())
+			Expect(repo.ApplyTemplates([]string{workflow}, map[string]interface{}{})).To(Succeed())
+			repo.ApplyRenames([]config.Rename{{
+				Match:   regexp.MustCompile("testdata/fixtures/local/(.*)"),
+				Replace: "%[1]s",
+			}})
+			repo.ApplyTemplateContext(TemplateContext{Downstream: "down", DefaultBranch: "main"})
+		})
+
+		g.It("gives templates the context", func() {
+			target := repo.Targets()["deep.yml"]
+			Expect(target.Vars).To(HaveKeyWithValue("templated", true))
+			meta, ok := target.Vars[config.ContextVar].(TemplateContext)
+			Expect(ok).To(BeTrue())
+			Expect(meta.URL).To(Equal(repo.URL))
+			Expect(meta.Commit).To(Equal(repo.Commit().String()))
+			Expect(meta.Resolved).To(Equal(repo.Resolved().Short()))
+			Expect(meta.Source).To(Equal("testdata/fixtures/local/deep.yml"))
+			Expect(meta.Destination).To(Equal("deep.yml"))
+			Expect(meta.Downstream).To(Equal("down"))
+		})
+
+		g.It("leaves the shared vars and plain files alone", func() {
+			Expect(vars).ToNot(HaveKey(config.ContextVar))
+			Expect(repo.Targets()["README.md"].Vars).To(BeNil())
+		})
+
+		g.It("renders templates with empty vars", func() {
+			target := repo.Targets()[workflow]
+			Expect(target.Vars).To(HaveKey(config.ContextVar))
+			// The fixture's ${{ github.sha }} is only plain text with [[ ]]
+			_, err := target.Bytes()
+			Expect(err).To(MatchError(ContainSubstring(`function "github" not defined`)))
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package repos
+
+import (
+	"errors"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/goccy/go-yaml"
+)
+
+// TemplateFuncs returns the functions available to every template, which are
+// the sprig library, https://masterminds.github.io/sprig/, and the YAML
+// functions and required from Helm.
+func TemplateFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	// Rendering shouldn't depend on who runs it, or leak their secrets
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+
+	funcs["toYaml"] = toYaml
+	funcs["fromYaml"] = fromYaml
+	funcs["fromYamlArray"] = fromYamlArray
+	funcs["required"] = required
+	return funcs
+}
+
+// toYaml returns the value as YAML, without a trailing newline so it can be
+// indented
+func toYaml(value interface{}) (string, error) {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// fromYaml returns the YAML document as a map
+func fromYaml(text string) (value map[string]interface{}, err error) {
+	value = map[string]interface{}{}
+	err = yaml.Unmarshal([]byte(text), &value)
+	return
+}
+
+// fromYamlArray returns the YAML document as a list
+func fromYamlArray(text string) (value []interface{}, err error) {
+	value = []interface{}{}
+	err = yaml.Unmarshal([]byte(text), &value)
+	return
+}
+
+// required returns the value, or an error with the message if it's nil or an
+// empty string
+func required(message string, value interface{}) (interface{}, error) {
+	if text, ok := value.(string); value == nil || ok && text == "" {
+		return nil, errors.New(message)
+	}
+	return value, nil
+}
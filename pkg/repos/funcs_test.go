@@ -0,0 +1,63 @@
+package repos_test
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	. "github.com/shakefu/commonrepo/pkg/repos"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestFuncs(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	// render returns the text rendered with the vars
+	render := func(text string, vars map[string]interface{}) (string, error) {
+		tmpl, err := template.New("test").Option("missingkey=error").Funcs(TemplateFuncs()).Parse(text)
+		Expect(err).ToNot(HaveOccurred())
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, vars)
+		return buf.String(), err
+	}
+
+	g.Describe("TemplateFuncs", func() {
+		vars := map[string]interface{}{
+			"name":  "common repo",
+			"empty": "",
+			"tags":  []interface{}{"a", "b"},
+			"env":   map[string]interface{}{"GOFLAGS": "-mod=mod"},
+		}
+
+		g.It("has sprig", func() {
+			Expect(render(`{{ .name | snakecase }} {{ .name | upper }}`, vars)).To(Equal("common_repo COMMON REPO"))
+			Expect(render(`{{ .empty | default "none" }} {{ coalesce .empty .name }}`, vars)).To(Equal("none common repo"))
+			Expect(render(`{{ semverCompare "^1.4" "1.5.0" }}`, vars)).To(Equal("true"))
+			Expect(render(`{{ "a.tar.gz" | ext }} {{ regexReplaceAll "o+" .name "0" }}`, vars)).To(Equal(".gz c0mm0n rep0"))
+			Expect(render(`{{ .tags | toJson }}`, vars)).To(Equal(`["a","b"]`))
+		})
+
+		g.It("doesn't read the environment", func() {
+			Expect(TemplateFuncs()).ToNot(HaveKey("env"))
+			Expect(TemplateFuncs()).ToNot(HaveKey("expandenv"))
+		})
+
+		g.It("converts to and from YAML", func() {
+			Expect(render("env:{{ .env | toYaml | nindent 2 }}\n", vars)).To(Equal("env:\n  GOFLAGS: -mod=mod\n"))
+			Expect(render(`{{ (fromYaml "a: {b: 1}").a.b }}`, vars)).To(Equal("1"))
+			Expect(render(`{{ index (fromYamlArray "[x, y]") 1 }}`, vars)).To(Equal("y"))
+			_, err := render(`{{ fromYaml "[a" }}`, vars)
+			Expect(err).To(HaveOccurred())
+		})
+
+		g.It("requires values", func() {
+			Expect(render(`{{ required "name is required" .name }}`, vars)).To(Equal("common repo"))
+			_, err := render(`{{ required "empty is required" .empty }}`, vars)
+			Expect(err).To(MatchError(ContainSubstring("empty is required")))
+		})
+	})
+}
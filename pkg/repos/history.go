@@ -0,0 +1,217 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Log is the commits between two refs in a repo's history
+type Log struct {
+	From    plumbing.Hash
+	To      plumbing.Hash
+	ToRef   plumbing.ReferenceName // What the to ref resolved to
+	Commits []Commit               // Newest first
+}
+
+// Commit is a commit in a repo's history and the files it changed
+type Commit struct {
+	Hash    plumbing.Hash
+	Author  string
+	When    time.Time
+	Subject string
+	Files   []string // Original names of the files changed from the first parent
+}
+
+// Short returns the abbreviated hash of the commit
+func (commit Commit) Short() string {
+	return commit.Hash.String()[:7]
+}
+
+// Log returns the commits reachable from the to ref but not from the from ref.
+// See LogContext.
+func (repo *Repo) Log(from string, to string) (log Log, err error) {
+	return repo.LogContext(context.Background(), from, to)
+}
+
+// LogContext returns the commits reachable from the to ref but not from the
+// from ref, newest first, with the files each one changed.
+//
+// Repos are cloned without history, so the full history of every branch and
+// tag is fetched first, deepening the cache when there is one. Both refs are
+// matched like Ref, including commit SHAs and version ranges. An empty from is
+// the cloned commit, and an empty to is the newest version tag, or the default
+// branch if there are no version tags.
+func (repo *Repo) LogContext(ctx context.Context, from string, to string) (log Log, err error) {
+	if err = repo.Check(); err != nil {
+		return
+	}
+	refs, err := repo.remoteRefs(ctx)
+	if err != nil {
+		return
+	}
+
+	var store storage.Storer = memory.NewStorage()
+	if dir := repo.cacheDir(); dir != "" {
+		var cached *filesystem.Storage
+		var done func()
+		if cached, done, err = openCache(ctx, dir); err != nil {
+			return
+		}
+		defer done()
+		store = cached
+	}
+	// Offline, whatever history the cache has is all there is
+	if !repo.offline() {
+		if err = repo.fetchHistory(ctx, store, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"); err != nil {
+			return
+		}
+	}
+
+	log.From = repo.commit
+	if from != "" {
+		if log.From, _, err = repo.resolve(store, refs, from); err != nil {
+			return
+		}
+	}
+	if to == "" {
+		to = newestVersion(refs, repo.Prerelease)
+	}
+	if log.To, log.ToRef, err = repo.resolve(store, refs, to); err != nil {
+		return
+	}
+	log.Commits, err = commitsBetween(store, log.From, log.To)
+	return
+}
+
+// newestVersion returns the newest version tag in the refs, or an empty string
+// for the default branch if there isn't one
+func newestVersion(refs memory.ReferenceStorage, prerelease bool) string {
+	all, _ := semver.NewConstraint("*")
+	if newest, err := gitutil.MatchVersion(refs, all, prerelease); err == nil {
+		return newest.String()
+	}
+	return ""
+}
+
+// resolve returns the commit in the store which the ref points at, and the
+// full name it was matched to
+func (repo *Repo) resolve(store storage.Storer, refs memory.ReferenceStorage, refname string) (hash plumbing.Hash, ref plumbing.ReferenceName, err error) {
+	version, err := config.VersionRange(refname)
+	if err != nil {
+		return
+	}
+	if ref, err = repo.matchRef(refs, refname, version); err != nil {
+		return
+	}
+
+	if gitutil.IsHash(ref.String()) {
+		if hash, err = findCommit(store, ref.String()); err == nil && hash.IsZero() {
+			err = fmt.Errorf("%w: no commit %s in %s", gitutil.ErrUnknownRef, ref, repo.URL)
+		}
+		return
+	}
+
+	found, ok := refs[ref]
+	if !ok {
+		return hash, ref, fmt.Errorf("%w: %s in %s", gitutil.ErrUnknownRef, ref, repo.URL)
+	}
+	if hash = found.Hash(); found.Type() == plumbing.SymbolicReference {
+		if found, ok = refs[found.Target()]; !ok {
+			return hash, ref, fmt.Errorf("%w: %s in %s", gitutil.ErrUnknownRef, ref, repo.URL)
+		}
+		ref, hash = found.Name(), found.Hash()
+	}
+	commit, err := peelCommit(store, hash)
+	if err != nil {
+		return
+	}
+	return commit.Hash, ref, nil
+}
+
+// commitsBetween returns the commits reachable from to but not from, newest
+// first
+func commitsBetween(store storage.Storer, from plumbing.Hash, to plumbing.Hash) (commits []Commit, err error) {
+	// Everything from can reach is already had
+	seen := make(map[plumbing.Hash]bool)
+	if !from.IsZero() {
+		var start *object.Commit
+		if start, err = object.GetCommit(store, from); err != nil {
+			return
+		}
+		err = object.NewCommitPreorderIter(start, nil, nil).ForEach(func(commit *object.Commit) error {
+			seen[commit.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	end, err := object.GetCommit(store, to)
+	if err != nil {
+		return
+	}
+	err = object.NewCommitIterCTime(end, seen, nil).ForEach(func(commit *object.Commit) error {
+		if seen[commit.Hash] {
+			return nil
+		}
+		files, err := changedFiles(commit)
+		if err != nil {
+			return err
+		}
+		commits = append(commits, Commit{
+			Hash:    commit.Hash,
+			Author:  commit.Author.Name,
+			When:    commit.Author.When,
+			Subject: strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0],
+			Files:   files,
+		})
+		return nil
+	})
+	return
+}
+
+// changedFiles returns the sorted names of the files the commit changed from
+// its first parent, or every file for a root commit
+func changedFiles(commit *object.Commit) (files []string, err error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return
+	}
+	var parent *object.Tree
+	if commit.NumParents() > 0 {
+		var first *object.Commit
+		if first, err = commit.Parent(0); err != nil {
+			return
+		}
+		if parent, err = first.Tree(); err != nil {
+			return
+		}
+	}
+	changes, err := object.DiffTree(parent, tree)
+	if err != nil {
+		return
+	}
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return
+}
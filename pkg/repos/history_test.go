@@ -0,0 +1,88 @@
+package repos_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	. "github.com/shakefu/commonrepo/internal/testutil"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+	. "github.com/shakefu/commonrepo/pkg/repos"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestHistory(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Log", func() {
+		var dir string
+		var tags map[string]plumbing.Hash
+		var readme plumbing.Hash
+		var repo *Repo
+
+		g.Before(func() {
+			var err error
+			dir, tags = TaggedRepo("v1.0.0", "v1.1.0")
+			Expect(os.WriteFile(filepath.Join(dir, "README.md"), []byte("# Tagged\n"), 0644)).To(Succeed())
+			readme = CommitFiles(dir, "Add a readme\n\nWith a body.", "README.md")
+			Expect(os.WriteFile(filepath.Join(dir, "VERSION"), []byte("v1.2.0"), 0644)).To(Succeed())
+			tags["v1.2.0"] = CommitFiles(dir, "v1.2.0", "VERSION")
+			TagCommit(dir, "v1.2.0", tags["v1.2.0"])
+
+			repo, err = New(dir, "v1.0.0")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		g.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("lists the commits between two refs, newest first", func() {
+			log, err := repo.Log("v1.0.0", "v1.2.0")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(log.From).To(Equal(tags["v1.0.0"]))
+			Expect(log.To).To(Equal(tags["v1.2.0"]))
+			Expect(log.ToRef).To(BeEquivalentTo("refs/tags/v1.2.0"))
+			Expect(log.Commits).To(HaveLen(3))
+			Expect(log.Commits[0].Hash).To(Equal(tags["v1.2.0"]))
+			Expect(log.Commits[1].Hash).To(Equal(readme))
+			Expect(log.Commits[2].Hash).To(Equal(tags["v1.1.0"]))
+		})
+
+		g.It("has the subject and files of each commit", func() {
+			log, err := repo.Log(tags["v1.1.0"].String()[:7], readme.String())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(log.Commits).To(HaveLen(1))
+			Expect(log.Commits[0].Subject).To(Equal("Add a readme"))
+			Expect(log.Commits[0].Author).To(Equal("test"))
+			Expect(log.Commits[0].Files).To(Equal([]string{"README.md"}))
+			Expect(log.Commits[0].Short()).To(Equal(readme.String()[:7]))
+		})
+
+		g.It("defaults to the cloned commit and the newest version", func() {
+			log, err := repo.Log("", "")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(log.From).To(Equal(tags["v1.0.0"]))
+			Expect(log.To).To(Equal(tags["v1.2.0"]))
+			Expect(log.Commits).To(HaveLen(3))
+		})
+
+		g.It("resolves version ranges", func() {
+			log, err := repo.Log("", "~1.1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(log.To).To(Equal(tags["v1.1.0"]))
+			Expect(log.Commits).To(HaveLen(1))
+		})
+
+		g.It("errors for unknown refs", func() {
+			_, err := repo.Log("", "v9.0.0")
+			Expect(errors.Is(err, gitutil.ErrUnknownRef)).To(BeTrue())
+		})
+	})
+}
@@ -0,0 +1,12 @@
+package repos_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/shakefu/commonrepo/internal/testutil"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testutil.Main(m))
+}
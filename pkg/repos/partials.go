@@ -0,0 +1,89 @@
+package repos
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/gobwas/glob"
+)
+
+// NewTemplate returns an empty template with the TemplateFuncs, which errors
+// for missing keys. It's used for the set of partials shared by every template.
+func NewTemplate(name string) *template.Template {
+	return template.New(name).Option("missingkey=error").Funcs(TemplateFuncs())
+}
+
+// PartialName returns the template name of a partial file, which is its base
+// name without the extension
+func PartialName(name string) string {
+	base := path.Base(name)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// ApplyPartials parses the files matching the partial globs into the set of
+// partials, and makes the set available to every template in the repo.
+//
+// Each file defines a template named by PartialName, as well as any it defines
+// itself, so a template can use {{ template "license-header" . }}. Partials
+// parsed later replace earlier ones with the same name, so parse them in the
+// inherited order. Each file is parsed with the delimiters of the ApplyDelims
+// rule matching it, so call that first. The files are removed from the targets,
+// since they're only used by other templates.
+func (repo *Repo) ApplyPartials(partials []string, set *template.Template) (err error) {
+	if err = repo.Check(); err != nil {
+		return
+	}
+	repo.partials = set
+
+	var found []string
+	for _, partial := range partials {
+		if found, err = repo.Glob(partial); err != nil {
+			return
+		}
+		for _, name := range found {
+			if err = repo.parsePartial(set, name); err != nil {
+				return
+			}
+			delete(repo.targets, name)
+			repo.trace(name).Partial = partial
+		}
+	}
+	return
+}
+
+// parsePartial parses the partial file into the set, with the delimiters of
+// the ApplyDelims rule matching it
+func (repo *Repo) parsePartial(set *template.Template, name string) (err error) {
+	data, err := repo.ReadFile(name)
+	if err != nil {
+		return
+	}
+	delims, err := repo.delimsFor(name)
+	if err != nil {
+		return
+	}
+	text, err := rawRegions(string(data), delims[0], delims[1])
+	if err != nil {
+		return fmt.Errorf("template: %s: %w", name, err)
+	}
+	_, err = set.New(PartialName(name)).Delims(delims[0], delims[1]).Parse(text)
+	return
+}
+
+// delimsFor returns the delimiters of the last rule from ApplyDelims matching
+// the file, or empty ones for {{ }}
+func (repo *Repo) delimsFor(name string) (delims [2]string, err error) {
+	var g glob.Glob
+	for _, rule := range repo.delims {
+		if g, err = glob.Compile(rule.Glob, filepath.Separator); err != nil {
+			return
+		}
+		if g.Match(name) {
+			delims = [2]string{rule.Left, rule.Right}
+		}
+	}
+	return
+}
@@ -0,0 +1,96 @@
+package repos_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/shakefu/commonrepo/pkg/config"
+	. "github.com/shakefu/commonrepo/pkg/repos"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestPartials(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("ApplyPartials", func() {
+		var repo *Repo
+		var err error
+		var uses = "testdata/fixtures/partials/uses.yml"
+		var vars = map[string]interface{}{"owner": "Example"}
+
+		g.Before(func() {
+			if repo, err = GetLocalRepo(); err != nil {
+				g.FailNow()
+			}
+		})
+
+		g.BeforeEach(func() {
+			repo.ResetTargets()
+			Expect(repo.ApplyIncludes([]string{"testdata/fixtures/partials/**"})).ToNot(BeEmpty())
+			Expect(repo.ApplyTemplates([]string{uses}, vars)).To(Succeed())
+		})
+
+		// render returns the rendered target
+		render := func(name string) string {
+			target := repo.Targets()[name]
+			rendered, err := target.Bytes()
+			Expect(err).ToNot(HaveOccurred())
+			return string(rendered)
+		}
+
+		g.It("names partials after the file", func() {
+			Expect(PartialName("_partials/license-header.tmpl")).To(Equal("license-header"))
+			Expect(PartialName("header")).To(Equal("header"))
+		})
+
+		g.It("lets templates use partials", func() {
+			Expect(repo.ApplyPartials([]string{"testdata/fixtures/partials/*.tmpl"},
+				NewTemplate("partials"))).To(Succeed())
+			Expect(render(uses)).To(Equal("# Copyright Example\n\ngreeting: hello Example\n"))
+		})
+
+		g.It("removes partials from the targets", func() {
+			Expect(repo.ApplyPartials([]string{"testdata/fixtures/partials/*.tmpl"},
+				NewTemplate("partials"))).To(Succeed())
+			Expect(SortTargetNames(repo.Targets())).To(Equal([]string{
+				"testdata/fixtures/partials/delims/owner.tmpl",
+				"testdata/fixtures/partials/override/license-header.tmpl",
+				uses,
+			}))
+			trace, ok := repo.Trace("testdata/fixtures/partials/helpers.tmpl")
+			Expect(ok).To(BeTrue())
+			Expect(trace.Partial).To(Equal("testdata/fixtures/partials/*.tmpl"))
+		})
+
+		g.It("lets later partials redefine earlier ones", func() {
+			set := NewTemplate("partials")
+			Expect(repo.ApplyPartials([]string{
+				"testdata/fixtures/partials/*.tmpl",
+				"testdata/fixtures/partials/override/*.tmpl",
+			}, set)).To(Succeed())
+			Expect(render(uses)).To(HavePrefix("# Licensed to Example\n"))
+		})
+
+		g.It("parses partials with the matching delimiters", func() {
+			Expect(repo.ApplyDelims([]config.DelimRule{
+				{Glob: "testdata/fixtures/partials/delims/*", Left: "[[", Right: "]]"},
+			})).To(Succeed())
+			set := NewTemplate("partials")
+			Expect(repo.ApplyPartials([]string{"testdata/fixtures/partials/delims/*.tmpl"}, set)).To(Succeed())
+			var buf bytes.Buffer
+			Expect(set.ExecuteTemplate(&buf, "owner", vars)).To(Succeed())
+			Expect(buf.String()).To(Equal("owned by Example {{ not an action }}"))
+		})
+
+		g.It("errors for templates using unknown partials", func() {
+			Expect(repo.ApplyPartials(nil, NewTemplate("partials"))).To(Succeed())
+			target := repo.Targets()[uses]
+			_, err := target.Bytes()
+			Expect(err).To(MatchError(ContainSubstring(`template "license-header" not defined`)))
+		})
+	})
+}
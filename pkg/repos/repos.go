@@ -2,13 +2,17 @@
 package repos
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"text/template"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/gobwas/glob"
 	"github.com/shakefu/commonrepo/pkg/common"
 	"github.com/shakefu/commonrepo/pkg/config"
@@ -25,35 +29,62 @@ import (
 
 // New returns a new Repo instance from the URL and tarrge ref
 func New(url string, refs ...string) (repo *Repo, err error) {
+	return NewContext(context.Background(), url, refs...)
+}
+
+// NewContext is New which gives up finding the ref or cloning when the context
+// is done.
+func NewContext(ctx context.Context, url string, refs ...string) (repo *Repo, err error) {
 	repo = &Repo{
 		URL: url,
 		Ref: append(refs, "")[0],
 	}
-	if err = repo.Init(); err != nil {
-		return nil, err
-	}
-	if err = repo.Clone(); err != nil {
+	if err = repo.LoadContext(ctx); err != nil {
 		return nil, err
 	}
 	return
 }
 
+// LoadContext initializes and clones the repo, giving up when the context is
+// done. It's for a Repo with options that New doesn't take.
+func (repo *Repo) LoadContext(ctx context.Context) (err error) {
+	// Cached refs and objects may not need the context at all, so make sure
+	// we're not already out of time
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if err = repo.InitContext(ctx); err != nil {
+		return
+	}
+	return repo.CloneContext(ctx)
+}
+
 // Repo is an in-memory git repository.
 type Repo struct {
 	// Requested URL and git ref, these may not be the same as actual
-	URL string
-	Ref string
+	URL        string
+	Ref        string
+	Prerelease bool   // Whether a version range Ref matches pre-release tags
+	requested  string // Ref before it's filled in with the default branch
+	version    *semver.Constraints
 	// Actual URL, git ref, options used to clone, and low-level Repository
-	url  string
-	ref  plumbing.ReferenceName
-	opts *git.CloneOptions
-	repo *git.Repository
+	url        string
+	ref        plumbing.ReferenceName
+	opts       *git.CloneOptions
+	repo       *git.Repository
+	advertised plumbing.Hash // What the remote said ref points to
+	pinned     bool          // Whether ref is a commit SHA rather than a name
+	commit     plumbing.Hash
 	// Filesystem and storage for the repository
 	fs    billy.Filesystem
 	store *memory.Storage
 	files []string
 	// Target files map
-	targets map[string]Target
+	targets  map[string]Target
+	shadowed []Shadowed
+	traces   map[string]*Trace
+	partials *template.Template // Shared by every upstream's templates
+	delims   []config.DelimRule // From ApplyDelims, for the partials
 	// State flags
 	inited bool
 	cloned bool
@@ -62,8 +93,15 @@ type Repo struct {
 // Init creates the memory filesystem and storage for the repository.
 //
 // This will make network requests to find the default branch, as well as read
-// the filesystem to load the gitconfig.
+// the filesystem to load the gitconfig. When running offline, refs are found in
+// the vendor directory and cache instead, and a full commit SHA which is already
+// cached doesn't need either.
 func (repo *Repo) Init() (err error) {
+	return repo.InitContext(context.Background())
+}
+
+// InitContext is Init which gives up finding the ref when the context is done.
+func (repo *Repo) InitContext(ctx context.Context) (err error) {
 	if repo.inited {
 		return errors.New("repo already initialized")
 	}
@@ -80,10 +118,31 @@ func (repo *Repo) Init() (err error) {
 	}
 
 	// We want to either use the default branch (main/master) or figure out if
-	// the ref we were given is a tag or a branch
-	if repo.ref, err = gitutil.FindRef(repo.url, repo.Ref); err != nil {
+	// the ref we were given is a tag or a branch. Offline, only the vendor
+	// directory and cache know about remote refs. A full commit SHA which is
+	// already cached doesn't need them at all.
+	repo.requested = repo.Ref
+	refs := make(memory.ReferenceStorage)
+	if !repo.cachedCommit(ctx, repo.Ref) {
+		if refs, err = repo.remoteRefs(ctx); err != nil {
+			return
+		}
+	}
+	if repo.version, err = config.VersionRange(repo.Ref); err != nil {
 		return
 	}
+	if repo.ref, err = repo.matchRef(refs, repo.Ref, repo.version); err != nil {
+		return
+	}
+	if found, ok := refs[repo.ref]; ok && found.Type() == plumbing.HashReference {
+		repo.advertised = found.Hash()
+	}
+	// Commits are fetched by hash, abbreviated ones once they're found
+	if repo.pinned = gitutil.IsHash(repo.ref.String()); repo.pinned {
+		if len(repo.ref) == len(plumbing.ZeroHash.String()) {
+			repo.advertised = plumbing.NewHash(repo.ref.String())
+		}
+	}
 
 	// Make our options for cloning
 	repo.opts = &git.CloneOptions{
@@ -105,12 +164,64 @@ func (repo *Repo) Init() (err error) {
 	return
 }
 
+// remoteRefs returns the refs the remote advertises, or the ones the vendor
+// directory and cache know about when running offline
+func (repo *Repo) remoteRefs(ctx context.Context) (refs memory.ReferenceStorage, err error) {
+	if repo.offline() {
+		return offlineRefs(repo.URL)
+	}
+	return advertisedRefs(ctx, repo.url)
+}
+
+// matchRef returns the full name of refname in the refs, or the highest tag
+// matching the version range. A branch or tag named like a version range, such
+// as 1.x, still wins.
+func (repo *Repo) matchRef(refs memory.ReferenceStorage, refname string, version *semver.Constraints) (ref plumbing.ReferenceName, err error) {
+	ref, err = gitutil.MatchRef(refs, refname)
+	if errors.Is(err, gitutil.ErrUnknownRef) && version != nil {
+		ref, err = gitutil.MatchVersion(refs, version, repo.Prerelease)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", repo.URL, err)
+	}
+	return
+}
+
 // Clone the given repository into this Repo and populate the list of files.
+//
+// When there's a cache directory, objects are fetched into it and kept between
+// runs, so only what's new is downloaded, and nothing is when the ref's commit
+// is already cached. Only the commit is fetched, not its history, and
+// repositories on this machine are never cached.
 func (repo *Repo) Clone() (err error) {
+	return repo.CloneContext(context.Background())
+}
+
+// CloneContext is Clone which gives up when the context is done.
+func (repo *Repo) CloneContext(ctx context.Context) (err error) {
 	if repo.cloned {
 		return errors.New("repo already cloned")
 	}
-	repo.repo, err = git.Clone(repo.store, repo.fs, repo.opts)
+	// Offline, a vendored snapshot of the commit is used before the cache
+	vendored := false
+	if repo.offline() {
+		if vendored, err = repo.cloneVendored(); err != nil {
+			return
+		}
+	}
+	switch dir := repo.cacheDir(); {
+	case vendored:
+		// The snapshot has every file
+	case dir != "":
+		err = repo.cloneCached(ctx, dir)
+	case repo.offline():
+		err = fmt.Errorf("%w: %s isn't vendored", gitutil.ErrOffline, repo)
+	case repo.pinned:
+		// Commits can't be cloned, only fetched
+		err = repo.cloneStore(ctx, repo.store)
+	default:
+		err = repo.cloneRemote(ctx)
+	}
 	if err != nil {
 		return
 	}
@@ -123,6 +234,26 @@ func (repo *Repo) Clone() (err error) {
 	return
 }
 
+// cloneRemote clones the ref straight into memory
+func (repo *Repo) cloneRemote(ctx context.Context) (err error) {
+	repo.repo, err = git.CloneContext(ctx, repo.store, repo.fs, repo.opts)
+	if err != nil {
+		return
+	}
+	// Remember which commit we actually got, since the ref may move
+	var head *plumbing.Reference
+	if head, err = repo.repo.Head(); err != nil {
+		return
+	}
+	repo.commit = head.Hash()
+	return
+}
+
+// offline returns true if the repo is remote and we can't contact it
+func (repo *Repo) offline() bool {
+	return common.Offline() && !gitutil.IsLocal(repo.url)
+}
+
 // Check makes sure the Repo has been initialized and cloned and is ready.
 func (repo *Repo) Check() (err error) {
 	if !repo.inited {
@@ -147,6 +278,18 @@ func (repo *Repo) list() (found []string, err error) {
 	return
 }
 
+// Size returns the total size in bytes of every file in the repository.
+func (repo *Repo) Size() (size int64, err error) {
+	var info os.FileInfo
+	for _, file := range repo.files {
+		if info, err = repo.fs.Stat(file); err != nil {
+			return
+		}
+		size += info.Size()
+	}
+	return
+}
+
 // Glob returns a list of file names matching the given pattern.
 func (repo *Repo) Glob(pattern string) (matches []string, err error) {
 	if err = repo.Check(); err != nil {
@@ -196,6 +339,10 @@ func (repo *Repo) GlobTargets(pattern string) (matches map[string]Target, err er
 
 // ResetTargets initializes the renamed map to the current list of files.
 func (repo *Repo) ResetTargets() {
+	repo.shadowed = nil
+	repo.traces = nil
+	repo.partials = nil
+	repo.delims = nil
 	repo.targets = make(map[string]Target, len(repo.files))
 	for _, file := range repo.files {
 		repo.targets[file] = Target{Name: file, repo: repo}
@@ -235,6 +382,9 @@ func (repo *Repo) ApplyIncludes(includes []string) (found map[string]Target, err
 		}
 		for k, v := range matched {
 			found[k] = v
+			if trace := repo.trace(v.Name); trace.Include == "" {
+				trace.Include = include
+			}
 		}
 	}
 	repo.targets = found
@@ -261,8 +411,9 @@ func (repo *Repo) ApplyExcludes(excludes []string) (targets map[string]Target, e
 		if err != nil {
 			return
 		}
-		for k := range matched {
+		for k, v := range matched {
 			delete(repo.targets, k)
+			repo.trace(v.Name).Exclude = include
 		}
 	}
 	return repo.targets, nil
@@ -288,6 +439,55 @@ func (repo *Repo) ApplyTemplates(templates []string, templateVars map[string]int
 		}
 		for _, name := range found {
 			repo.targets[name] = Target{Name: name, Vars: templateVars, repo: repo}
+			repo.trace(name).Template = include
+		}
+	}
+	return
+}
+
+// ApplyDelims sets the action delimiters of the template targets matching each
+// rule.
+//
+// Rules are applied in order, so the last matching rule wins. The rules are
+// kept for ApplyPartials, since partials aren't targets.
+func (repo *Repo) ApplyDelims(rules []config.DelimRule) (err error) {
+	if err = repo.Check(); err != nil {
+		return
+	}
+	repo.delims = rules
+
+	var matched map[string]Target
+	for _, rule := range rules {
+		if matched, err = repo.GlobTargets(rule.Glob); err != nil {
+			return
+		}
+		for name, target := range matched {
+			if target.Vars == nil {
+				continue
+			}
+			target.Delims = [2]string{rule.Left, rule.Right}
+			repo.targets[name] = target
+		}
+	}
+	return
+}
+
+// ApplyPolicies sets the write policy of the targets matching each rule.
+//
+// Rules are applied in order, so the last matching rule wins.
+func (repo *Repo) ApplyPolicies(rules []config.PolicyRule) (err error) {
+	if err = repo.Check(); err != nil {
+		return
+	}
+
+	var matched map[string]Target
+	for _, rule := range rules {
+		if matched, err = repo.GlobTargets(rule.Glob); err != nil {
+			return
+		}
+		for name, target := range matched {
+			target.Policy = rule.Policy
+			repo.targets[name] = target
 		}
 	}
 	return
@@ -320,16 +520,26 @@ func (repo *Repo) ApplyRenames(renames []config.Rename) map[string]Target {
 	for _, rename := range renames {
 		// Iterate over the files applying our rename
 		for _, name := range targets {
-			if !rename.Check(name) {
+			traced := TraceRenames([]config.Rename{rename}, name)
+			if len(traced) == 0 {
 				continue
 			}
-			rname := rename.Apply(name)
-			if rname != name {
-				// Save the rename
-				repo.targets[rname] = repo.targets[name]
-				// Remove the original entry
-				delete(repo.targets, name)
+			// An earlier rename may have moved it already
+			target, ok := repo.targets[name]
+			if !ok {
+				continue
+			}
+			rname := traced[0].Name
+			// Remember anything we're about to clobber
+			if existing, ok := repo.targets[rname]; ok {
+				repo.shadowed = append(repo.shadowed, Shadowed{rname, existing})
 			}
+			// Save the rename
+			repo.targets[rname] = target
+			// Remove the original entry
+			delete(repo.targets, name)
+			trace := repo.trace(target.Name)
+			trace.Renames = append(trace.Renames, traced...)
 		}
 	}
 
@@ -337,6 +547,31 @@ func (repo *Repo) ApplyRenames(renames []config.Rename) map[string]Target {
 	return repo.targets
 }
 
+// Shadowed returns the targets which were replaced by renaming another target
+// to the same name, in the order it happened
+func (repo *Repo) Shadowed() []Shadowed {
+	return repo.shadowed
+}
+
+// String satisfies the stringer interface and returns url@ref, followed by the
+// tag a version range resolved to
+func (repo *Repo) String() string {
+	if repo.version != nil && repo.ref.IsTag() {
+		return repo.URL + "@" + repo.Ref + " (" + repo.ref.Short() + ")"
+	}
+	return repo.URL + "@" + repo.Ref
+}
+
+// Resolved returns the full reference name that Ref was resolved to
+func (repo *Repo) Resolved() plumbing.ReferenceName {
+	return repo.ref
+}
+
+// Commit returns the hash of the commit that was cloned
+func (repo *Repo) Commit() plumbing.Hash {
+	return repo.commit
+}
+
 // Stat returns fs.FileInfo from stat() on a file name
 func (repo *Repo) Stat(name string) (os.FileInfo, error) {
 	return repo.fs.Stat(name)
@@ -347,8 +582,8 @@ func (repo *Repo) FS() billy.Filesystem {
 	return repo.fs
 }
 
-// findConfig returns the shortest matching path in this Repo's files
-func (repo *Repo) findConfig(search ...string) (path string, err error) {
+// FindConfig returns the shortest matching path in this Repo's files
+func (repo *Repo) FindConfig(search ...string) (path string, err error) {
 	var pattern = common.ConfigFileGlob()
 	if len(search) > 0 {
 		pattern = search[0]
@@ -376,7 +611,7 @@ func (repo *Repo) findConfig(search ...string) (path string, err error) {
 func (repo *Repo) readConfig(search ...string) (yaml []byte, err error) {
 	// Read the config
 	var path string
-	path, err = repo.findConfig(search...)
+	path, err = repo.FindConfig(search...)
 	if err != nil {
 		return
 	}
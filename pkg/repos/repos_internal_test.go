@@ -1,7 +1,17 @@
 package repos
 
 import (
+	"context"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/shakefu/commonrepo/pkg/common"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
 
 	. "github.com/onsi/gomega"
 	"github.com/shakefu/goblin"
@@ -21,24 +31,37 @@ func TestRepoInternal(t *testing.T) {
 			Expect(repo.files).To(ContainElement("pkg/repos/repos.go"))
 		})
 
-		g.Describe("findConfig", func() {
+		g.Describe("rawRegions", func() {
+			g.It("quotes each line of the region", func() {
+				text, err := rawRegions("a<%raw%>b\n<% c %>\n<%endraw%>d", "<%", "%>")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(text).To(Equal("a<%\"b\"%>\n<%\"<% c %>\"%>\nd"))
+			})
+
+			g.It("errors without an endraw", func() {
+				_, err := rawRegions("{{ raw }}{{ end }}", "", "")
+				Expect(err).To(MatchError(ContainSubstring("no endraw")))
+			})
+		})
+
+		g.Describe("FindConfig", func() {
 			g.It("works", func() {
 				repo := localRepo()
-				path, err := repo.findConfig()
+				path, err := repo.FindConfig()
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(path).To(Equal(".commonrepo.yml"))
 			})
 
 			g.It("lets you search a different path", func() {
 				repo := localRepo()
-				path, err := repo.findConfig("**/fixtures/.commonrepo.{yml,yaml}")
+				path, err := repo.FindConfig("**/fixtures/.commonrepo.{yml,yaml}")
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(path).To(Equal("testdata/fixtures/.commonrepo.yaml"))
 			})
 
 			g.It("sorts by shortest match", func() {
 				repo := localRepo()
-				path, err := repo.findConfig("**commonrepo.{yml,yaml}")
+				path, err := repo.FindConfig("**commonrepo.{yml,yaml}")
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(path).To(Equal(".commonrepo.yml"))
 			})
@@ -81,9 +104,117 @@ func TestRepoInternal(t *testing.T) {
 				Expect(config.Exclude).To(HaveLen(1))
 			})
 		})
+
+		g.Describe("cache", func() {
+			var root string
+			var path string
+			var dir string
+
+			g.Before(func() {
+				var err error
+				if root, err = os.MkdirTemp("", "commonrepo-cache-"); err != nil {
+					g.FailNow()
+				}
+				if path, err = gitutil.FindLocalRepoPath(); err != nil {
+					g.FailNow()
+				}
+				dir = CachePath(root, path)
+			})
+
+			g.After(func() {
+				os.RemoveAll(root)
+			})
+
+			// openStore returns the cache's storage for the local repo
+			openStore := func() *filesystem.Storage {
+				return filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+			}
+
+			g.It("only fetches the commit", func() {
+				repo := cachedRepo(path, dir)
+				store := openStore()
+				defer store.Close()
+				Expect(store.HasEncodedObject(repo.commit)).To(Succeed())
+				Expect(store.Shallow()).To(Equal([]plumbing.Hash{repo.commit}))
+			})
+
+			g.It("checks out the same files as an uncached clone", func() {
+				cached := cachedRepo(path, dir)
+				uncached := localRepo()
+				Expect(cached.commit).To(Equal(uncached.commit))
+				Expect(cached.files).To(Equal(uncached.files))
+				for _, name := range []string{"LICENSE", "action/run.sh"} {
+					want, err := uncached.Stat(name)
+					Expect(err).ToNot(HaveOccurred())
+					got, err := cached.Stat(name)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(got.Mode()).To(Equal(want.Mode()))
+					Expect(got.Size()).To(Equal(want.Size()))
+				}
+			})
+
+			g.It("deepens the cache for history", func() {
+				repo := cachedRepo(path, dir)
+				store := openStore()
+				defer store.Close()
+				Expect(repo.fetchHistory(context.Background(), store, "+HEAD:refs/heads/history")).To(Succeed())
+				Expect(store.Shallow()).To(BeEmpty())
+				commit, err := peelCommit(store, repo.commit)
+				Expect(err).ToNot(HaveOccurred())
+				for _, parent := range commit.ParentHashes {
+					Expect(store.HasEncodedObject(parent)).To(Succeed())
+				}
+			})
+
+			g.It("doesn't ask the remote about cached commits", func() {
+				// Nothing answers for this URL, so only the cache can load it
+				const URL = "https://example.invalid/commonrepo"
+				local := cachedRepo(path, CachePath(common.CacheDir(), URL))
+
+				repo, err := New(URL, local.commit.String())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(repo.commit).To(Equal(local.commit))
+				Expect(repo.files).To(Equal(local.files))
+			})
+
+			g.It("waits for another run's lock", func() {
+				unlock, err := lockCache(context.Background(), dir)
+				Expect(err).ToNot(HaveOccurred())
+				ctx, cancel := context.WithTimeout(context.Background(), 4*cacheLockWait)
+				defer cancel()
+				_, err = lockCache(ctx, dir)
+				Expect(err).To(MatchError(context.DeadlineExceeded))
+
+				unlock()
+				unlock, err = lockCache(context.Background(), dir)
+				Expect(err).ToNot(HaveOccurred())
+				unlock()
+			})
+
+			g.It("takes over stale locks", func() {
+				Expect(os.WriteFile(dir+".lock", nil, 0644)).To(Succeed())
+				stale := time.Now().Add(-2 * cacheLockStale)
+				Expect(os.Chtimes(dir+".lock", stale, stale)).To(Succeed())
+				unlock, err := lockCache(context.Background(), dir)
+				Expect(err).ToNot(HaveOccurred())
+				unlock()
+			})
+		})
 	})
 }
 
+// cachedRepo loads the repo at path through the cache directory, which local
+// repositories skip otherwise
+func cachedRepo(path string, dir string) (repo *Repo) {
+	repo = &Repo{URL: path}
+	Expect(repo.Init()).To(Succeed())
+	Expect(repo.cloneCached(context.Background(), dir)).To(Succeed())
+	files, err := repo.list()
+	Expect(err).ShouldNot(HaveOccurred())
+	repo.files = files
+	return
+}
+
 // localRepo returns the local repo or raises an error.
 //
 // This is a reimplementation from testutil to prevent import cycle.
@@ -1,13 +1,18 @@
 package repos_test
 
 import (
+	"context"
+	"errors"
 	"os"
 	"testing"
 
 	"github.com/shakefu/commonrepo/pkg/common"
 	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
 	. "github.com/shakefu/commonrepo/pkg/repos"
 
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	. "github.com/onsi/gomega"
 	. "github.com/shakefu/commonrepo/internal/testutil"
 	"github.com/shakefu/goblin"
@@ -47,6 +52,100 @@ func TestRepo(t *testing.T) {
 				Expect(repo).ShouldNot(BeNil())
 			})
 
+			g.Describe("pinned commits", func() {
+				var path string
+				var parent plumbing.Hash
+
+				g.Before(func() {
+					if path, err = gitutil.FindLocalRepoPath(); err != nil {
+						g.FailNow()
+					}
+					local, err := git.PlainOpen(path)
+					if err != nil {
+						g.FailNow()
+					}
+					commit, err := local.CommitObject(repo.Commit())
+					if err != nil || commit.NumParents() == 0 {
+						g.FailNow()
+					}
+					parent = commit.ParentHashes[0]
+				})
+
+				g.It("clones a full SHA", func() {
+					pinned, err := New(path, repo.Commit().String())
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(pinned.Commit()).To(Equal(repo.Commit()))
+					Expect(pinned.Resolved()).To(BeEquivalentTo(repo.Commit().String()))
+					Expect(pinned.Files()).To(Equal(repo.Files()))
+				})
+
+				g.It("clones an abbreviated SHA", func() {
+					pinned, err := New(path, repo.Commit().String()[:10])
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(pinned.Commit()).To(Equal(repo.Commit()))
+				})
+
+				g.It("clones commits which aren't a branch or tag", func() {
+					pinned, err := New(path, parent.String()[:10])
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(pinned.Commit()).To(Equal(parent))
+					Expect(pinned.Resolved()).To(BeEquivalentTo(parent.String()))
+				})
+
+				g.It("errors for commits which don't exist", func() {
+					_, err := New(path, "0123456789abcdef")
+					Expect(errors.Is(err, gitutil.ErrUnknownRef)).To(BeTrue())
+				})
+
+				g.It("errors for unknown refs instead of using the default branch", func() {
+					_, err := New(path, "no-such-branch")
+					Expect(errors.Is(err, gitutil.ErrUnknownRef)).To(BeTrue())
+				})
+			})
+
+			g.Describe("version ranges", func() {
+				var dir string
+				var tags map[string]plumbing.Hash
+
+				g.Before(func() {
+					dir, tags = TaggedRepo("v1.4.0", "v1.4.2", "v1.5.0-rc.1", "v2.0.0")
+				})
+
+				g.After(func() {
+					os.RemoveAll(dir)
+				})
+
+				g.It("clones the highest matching tag", func() {
+					ranged, err := New(dir, "^1.4")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(ranged.Resolved()).To(BeEquivalentTo("refs/tags/v1.4.2"))
+					Expect(ranged.Commit()).To(Equal(tags["v1.4.2"]))
+					Expect(ranged.ReadFile("VERSION")).To(BeEquivalentTo("v1.4.2"))
+					Expect(ranged.String()).To(Equal(dir + "@^1.4 (v1.4.2)"))
+				})
+
+				g.It("includes pre-releases when asked", func() {
+					ranged := &Repo{URL: dir, Ref: "^1.4", Prerelease: true}
+					Expect(ranged.LoadContext(context.Background())).To(Succeed())
+					Expect(ranged.Resolved()).To(BeEquivalentTo("refs/tags/v1.5.0-rc.1"))
+				})
+
+				g.It("errors when no tag matches", func() {
+					_, err := New(dir, ">=3.0.0")
+					Expect(errors.Is(err, gitutil.ErrUnknownRef)).To(BeTrue())
+				})
+			})
+
+			g.Describe("Size", func() {
+				g.It("adds up every file", func() {
+					size, err := repo.Size()
+					Expect(err).ShouldNot(HaveOccurred())
+					info, err := repo.Stat("go.mod")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(size).To(BeNumerically(">", info.Size()))
+				})
+			})
+
 			g.Describe("Glob", func() {
 				g.It("globs nicely", func() {
 					files, err := repo.Glob("go.*")
@@ -202,6 +301,91 @@ func TestRepo(t *testing.T) {
 				})
 			})
 
+			g.Describe("Trace", func() {
+				g.Before(func() {
+					if repo, err = GetLocalRepo(); err != nil {
+						g.FailNow()
+					}
+				})
+				g.AfterEach(func() { repo.ResetTargets() })
+
+				g.It("records each step", func() {
+					cfg, err := config.ParseConfig(InlineYaml(`
+						rename:
+						  - "^testdata/fixtures/local/(.*)": "out/%[1]s"`))
+					Expect(err).ToNot(HaveOccurred())
+					_, err = repo.ApplyIncludes([]string{"testdata/fixtures/local/*.yml"})
+					Expect(err).ToNot(HaveOccurred())
+					_, err = repo.ApplyExcludes([]string{"**/deep.yml"})
+					Expect(err).ToNot(HaveOccurred())
+					repo.ApplyRenames(cfg.Rename)
+
+					trace, ok := repo.Trace("testdata/fixtures/local/single.yml")
+					Expect(ok).To(BeTrue())
+					Expect(trace.Include).To(Equal("testdata/fixtures/local/*.yml"))
+					Expect(trace.Exclude).To(BeEmpty())
+					Expect(trace.Renames).To(HaveLen(1))
+					Expect(trace.Renames[0].Name).To(Equal("out/single.yml"))
+
+					trace, ok = repo.Trace("testdata/fixtures/local/deep.yml")
+					Expect(ok).To(BeTrue())
+					Expect(trace.Exclude).To(Equal("**/deep.yml"))
+					Expect(trace.Renames).To(BeEmpty())
+				})
+
+				g.It("has empty traces for untouched files", func() {
+					trace, ok := repo.Trace("README.md")
+					Expect(ok).To(BeTrue())
+					Expect(trace).To(Equal(Trace{Name: "README.md"}))
+				})
+
+				g.It("doesn't trace missing files", func() {
+					_, ok := repo.Trace("nope.md")
+					Expect(ok).To(BeFalse())
+				})
+
+				g.It("traces renames of missing files the same as ApplyRenames", func() {
+					cfg, err := config.ParseConfig(InlineYaml(`
+						rename:
+						  - "^nope/(.*)": "nope/%[1]s"
+						  - "^nope/(.*)": "out/%[1]s"
+						  - "^(.*)": "again/%[1]s"`))
+					Expect(err).ToNot(HaveOccurred())
+					trace := Trace{Name: "nope/file.md", Renames: TraceRenames(cfg.Rename, "nope/file.md")}
+					Expect(trace.Renames).To(HaveLen(1))
+					Expect(trace.Destination()).To(Equal("out/file.md"))
+					Expect(Trace{Name: "nope.md"}.Destination()).To(Equal("nope.md"))
+				})
+			})
+
+			g.Describe("ApplyPolicies", func() {
+				g.Before(func() {
+					if repo, err = GetLocalRepo(); err != nil {
+						g.FailNow()
+					}
+				})
+				g.AfterEach(func() { repo.ResetTargets() })
+
+				g.It("works", func() {
+					err := repo.ApplyPolicies([]config.PolicyRule{
+						{Glob: "**", Policy: config.PolicyNeverIfModified},
+						{Glob: "README.md", Policy: config.PolicyCreateOnly},
+					})
+					Expect(err).ToNot(HaveOccurred())
+					targets := repo.Targets()
+					Expect(targets["README.md"].Policy).To(Equal(config.PolicyCreateOnly))
+					Expect(targets["LICENSE"].Policy).To(Equal(config.PolicyNeverIfModified))
+				})
+
+				g.It("leaves unmatched targets alone", func() {
+					err := repo.ApplyPolicies([]config.PolicyRule{
+						{Glob: "README.md", Policy: config.PolicyCreateOnly},
+					})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(repo.Targets()["LICENSE"].Policy).To(BeEmpty())
+				})
+			})
+
 			g.Describe("Renames", func() {
 				var cfg *config.Config
 
@@ -251,6 +435,35 @@ func TestRepo(t *testing.T) {
 					// Expect(renamed).To(Equal(map[string]string{}))
 					Expect(renamed[".commonrepo.yml"].Name).To(Equal("testdata/fixtures/single_source.yml"))
 				})
+
+				g.It("records targets shadowed by a rename", func() {
+					conf, err := config.ParseConfig(InlineYaml(`
+						rename:
+							- "^LICENSE$": "README.md"
+					`))
+					Expect(err).ShouldNot(HaveOccurred())
+					renamed := repo.ApplyRenames(conf.Rename)
+					Expect(renamed["README.md"].Name).To(Equal("LICENSE"))
+					shadowed := repo.Shadowed()
+					Expect(shadowed).To(HaveLen(1))
+					Expect(shadowed[0].Name).To(Equal("README.md"))
+					Expect(shadowed[0].Target.Name).To(Equal("README.md"))
+
+					repo.ResetTargets()
+					Expect(repo.Shadowed()).To(BeEmpty())
+				})
+
+				g.It("doesn't rename a target twice from its original name", func() {
+					conf, err := config.ParseConfig(InlineYaml(`
+						rename:
+							- "^LICENSE$": "LICENSE.txt"
+							- "^LICENSE": "COPYING"
+					`))
+					Expect(err).ShouldNot(HaveOccurred())
+					renamed := repo.ApplyRenames(conf.Rename)
+					Expect(renamed["LICENSE.txt"].Name).To(Equal("LICENSE"))
+					Expect(renamed).ToNot(HaveKey("COPYING"))
+				})
 			})
 
 			g.Describe("GlobRenamed", func() {
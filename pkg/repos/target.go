@@ -1,17 +1,34 @@
 package repos
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"text/template"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shakefu/commonrepo/pkg/config"
 )
 
 // Target represents a single target file or template
 type Target struct {
-	Name string                 // Original file name
-	Vars map[string]interface{} // Template variables, if it is a template
-	repo *Repo                  // Source repo, for reading the file content
+	Name   string                 // Original file name
+	Vars   map[string]interface{} // Template variables, if it is a template
+	Delims [2]string              // Template action delimiters, empty for {{ }}
+	Policy config.Policy          // Write policy, empty means always
+	Merge  *config.MergeRule      // How to combine with the Layers, if at all
+	Layers []Target               // Earlier targets of the same name, when merging
+	repo   *Repo                  // Source repo, for reading the file content
+}
+
+// Shadowed is a target which was replaced when another was renamed to the same
+// destination
+type Shadowed struct {
+	Name   string // Destination name both targets were renamed to
+	Target Target // The target which was replaced
 }
 
 // String returns a Target as a string
@@ -22,6 +39,11 @@ func (targ *Target) String() string {
 	return fmt.Sprintf("<Repo.Template:%s>", targ.Name)
 }
 
+// Repo returns the Repo the target is read from
+func (targ *Target) Repo() *Repo {
+	return targ.repo
+}
+
 // Stat returns the os.FileInfo for the target
 func (targ *Target) Stat() (os.FileInfo, error) {
 	return targ.repo.Stat(targ.Name)
@@ -38,6 +60,15 @@ func (targ *Target) Write(dest io.Writer) (err error) {
 	return targ.RenderTo(dest)
 }
 
+// Bytes returns the rendered content of the target
+func (targ *Target) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := targ.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // CopyTo copies the given file to the given writer
 func (targ *Target) CopyTo(dest io.Writer) (err error) {
 	// Open the file
@@ -63,22 +94,73 @@ func (targ *Target) CopyTo(dest io.Writer) (err error) {
 	return
 }
 
-// RenderTo renders the template with the current Vars
+// RenderTo renders the template with the current Vars, the TemplateFuncs and
+// the partials from ApplyPartials, using the Delims if they're set. Anything
+// between raw and endraw actions, like {{raw}}${{ github.sha }}{{endraw}}, is
+// written as is.
 func (targ *Target) RenderTo(dest io.Writer) (err error) {
 	// TBD: Test passing repo.fs and globbing, it might be faster
 	var data []byte
 	if data, err = targ.repo.ReadFile(targ.Name); err != nil {
 		return
 	}
-	// Try to parse the template
-	// TBD: Should there be a set of standard functions?
-	// e.g. https://github.com/Masterminds/sprig
-	var tmpl *template.Template
-	tmpl = template.New(targ.Name).Option("missingkey=error")
-	if tmpl, err = tmpl.Parse(string(data)); err != nil {
+	// Raw regions are turned into plain strings before anything can parse them
+	left, right := targ.Delims[0], targ.Delims[1]
+	var text string
+	if text, err = rawRegions(string(data), left, right); err != nil {
+		return fmt.Errorf("template: %s: %w", targ.Name, err)
+	}
+	// Try to parse the template, alongside its own copy of the partials so
+	// it can redefine them
+	tmpl := NewTemplate(targ.Name)
+	if partials := targ.repo.partials; partials != nil {
+		if tmpl, err = partials.Clone(); err != nil {
+			return
+		}
+		tmpl = tmpl.New(targ.Name)
+	}
+	if tmpl, err = tmpl.Delims(left, right).Parse(text); err != nil {
 		return
 	}
 	// Render it out to our destination file
 	err = tmpl.Execute(dest, targ.Vars)
 	return
 }
+
+// rawRegions replaces the text between each raw and endraw action with actions
+// printing it as is, one per line so parse errors keep their line numbers
+func rawRegions(text string, left string, right string) (string, error) {
+	if left == "" {
+		left = "{{"
+	}
+	if right == "" {
+		right = "}}"
+	}
+	start := regexp.MustCompile(regexp.QuoteMeta(left) + `\s*raw\s*` + regexp.QuoteMeta(right))
+	end := regexp.MustCompile(regexp.QuoteMeta(left) + `\s*endraw\s*` + regexp.QuoteMeta(right))
+
+	var out strings.Builder
+	for {
+		found := start.FindStringIndex(text)
+		if found == nil {
+			out.WriteString(text)
+			return out.String(), nil
+		}
+		out.WriteString(text[:found[0]])
+		text = text[found[1]:]
+
+		found = end.FindStringIndex(text)
+		if found == nil {
+			return "", errors.New("raw region has no endraw")
+		}
+		for i, line := range strings.Split(text[:found[0]], "\n") {
+			if i > 0 {
+				out.WriteString("\n")
+			}
+			if line != "" {
+				out.WriteString(left + strconv.Quote(line) + right)
+			}
+		}
+		text = text[found[1]:]
+	}
+}
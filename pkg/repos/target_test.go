@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/shakefu/commonrepo/pkg/config"
 	. "github.com/shakefu/commonrepo/pkg/repos"
 
 	. "github.com/onsi/gomega"
@@ -80,5 +81,37 @@ func TestTarget(t *testing.T) {
 			Expect(buf.String()).To(ContainSubstring("templated: true"))
 			Expect(buf.String()).To(ContainSubstring("project: commonrepo"))
 		})
+
+		g.Describe("delimiters", func() {
+			var vars = map[string]interface{}{"project": "commonrepo", "version": "1.0.0"}
+
+			// render returns the named fixture rendered with the rules
+			render := func(name string, rules []config.DelimRule) (string, error) {
+				repo.ResetTargets()
+				Expect(repo.ApplyTemplates([]string{name}, vars)).To(Succeed())
+				Expect(repo.ApplyDelims(rules)).To(Succeed())
+				target := repo.Targets()[name]
+				rendered, err := target.Bytes()
+				return string(rendered), err
+			}
+
+			g.It("uses the delimiters of the matching rule", func() {
+				rendered, err := render("testdata/fixtures/templates/workflow.yml", []config.DelimRule{
+					{Glob: "**/*.yml", Left: "<%", Right: "%>"},
+					{Glob: "**/workflow.yml", Left: "[[", Right: "]]"},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rendered).To(HavePrefix("name: commonrepo\n"))
+				Expect(rendered).To(ContainSubstring(`echo "${{ github.sha }}" 1.0.0`))
+				Expect(rendered).To(ContainSubstring(`echo "[[ not a template ]]"`))
+			})
+
+			g.It("leaves raw regions alone", func() {
+				rendered, err := render("testdata/fixtures/templates/raw.tmpl", nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rendered).To(Equal("commonrepo\n" +
+					"{{ .project }} and {{ template \"x\" }}\n\"quoted\" `ticks`\n"))
+			})
+		})
 	})
 }
@@ -0,0 +1,80 @@
+package repos
+
+import (
+	"github.com/shakefu/commonrepo/pkg/config"
+)
+
+// Trace records how a file in the repository became a target, or why it didn't
+type Trace struct {
+	Name     string         // Original file name
+	Include  string         // Include glob which matched, empty if none did
+	Template string         // Template glob which matched, if any
+	Exclude  string         // Exclude glob which removed it, if any
+	Partial  string         // Partial glob which matched, if any
+	Renames  []TracedRename // Renames which moved it, in the order applied
+}
+
+// TracedRename is a rename which moved a target, and where it moved it to
+type TracedRename struct {
+	Rename config.Rename
+	Name   string
+}
+
+// Trace returns how the original file name became a target since the targets
+// were last reset, or false if the repository doesn't have the file
+func (repo *Repo) Trace(name string) (trace Trace, ok bool) {
+	var found *Trace
+	if found, ok = repo.traces[name]; ok {
+		trace = *found
+		trace.Renames = append([]TracedRename{}, found.Renames...)
+		return
+	}
+	for _, file := range repo.files {
+		if file == name {
+			return Trace{Name: name}, true
+		}
+	}
+	return
+}
+
+// Destination returns the name the renames moved the file to, or its original
+// name if they didn't
+func (trace Trace) Destination() string {
+	if len(trace.Renames) == 0 {
+		return trace.Name
+	}
+	return trace.Renames[len(trace.Renames)-1].Name
+}
+
+// TraceRenames returns the rename which would move a file with the original
+// name, which is the first one changing it, the same as ApplyRenames. It works
+// for names the repository doesn't have.
+func TraceRenames(renames []config.Rename, name string) []TracedRename {
+	for _, rename := range renames {
+		if !rename.Check(name) {
+			continue
+		}
+		if renamed := rename.Apply(name); renamed != name {
+			return []TracedRename{{rename, renamed}}
+		}
+	}
+	return nil
+}
+
+// Files returns the names of every file in the repository
+func (repo *Repo) Files() []string {
+	return repo.files
+}
+
+// trace returns the Trace for the original file name, creating it if needed
+func (repo *Repo) trace(name string) *Trace {
+	if repo.traces == nil {
+		repo.traces = make(map[string]*Trace)
+	}
+	found, ok := repo.traces[name]
+	if !ok {
+		found = &Trace{Name: name}
+		repo.traces[name] = found
+	}
+	return found
+}
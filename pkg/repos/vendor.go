@@ -0,0 +1,255 @@
+package repos
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/goccy/go-yaml"
+	"github.com/shakefu/commonrepo/pkg/common"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// VendorIndex is the file in a vendor directory listing the upstreams in it
+const VendorIndex = "vendor.yml"
+
+// Vendored is an upstream snapshot in a vendor directory
+type Vendored struct {
+	URL      string `yaml:"url"`
+	Ref      string `yaml:"ref,omitempty"` // Requested ref, empty for the default branch
+	Resolved string `yaml:"resolved"`      // Full reference name Ref resolved to
+	Commit   string `yaml:"commit"`
+	Path     string `yaml:"path"` // Directory of the snapshot, relative to the vendor directory
+}
+
+// ReadVendor returns the upstreams in the vendor directory, or nothing if it
+// doesn't have an index.
+func ReadVendor(dir string) (vendored []Vendored, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, VendorIndex))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	err = yaml.Unmarshal(data, &vendored)
+	return
+}
+
+// Vendor writes a snapshot of every file in each repo to the vendor directory
+// and indexes them, so they can be loaded while running offline. Snapshots
+// from the previous index which aren't needed anymore are removed.
+func Vendor(dir string, repos ...*Repo) (vendored []Vendored, err error) {
+	previous, err := ReadVendor(dir)
+	if err != nil {
+		return
+	}
+
+	written := make(map[string]bool)
+	for _, repo := range repos {
+		entry := Vendored{
+			URL:      repo.URL,
+			Ref:      repo.requested,
+			Resolved: repo.ref.String(),
+			Commit:   repo.commit.String(),
+			Path:     filepath.Join(filepath.Base(CachePath("", repo.URL)), repo.commit.String()),
+		}
+		vendored = append(vendored, entry)
+		if written[entry.Path] {
+			continue
+		}
+		written[entry.Path] = true
+		if err = repo.snapshot(filepath.Join(dir, entry.Path)); err != nil {
+			return
+		}
+	}
+
+	for _, entry := range previous {
+		if !written[entry.Path] {
+			if err = os.RemoveAll(filepath.Join(dir, entry.Path)); err != nil {
+				return
+			}
+		}
+	}
+
+	data, err := yaml.Marshal(vendored)
+	if err != nil {
+		return
+	}
+	err = os.WriteFile(filepath.Join(dir, VendorIndex), data, 0644)
+	return
+}
+
+// snapshot copies every file in the repo to the directory, replacing whatever
+// was there
+func (repo *Repo) snapshot(dir string) (err error) {
+	if err = os.RemoveAll(dir); err != nil {
+		return
+	}
+	for _, name := range repo.files {
+		var info os.FileInfo
+		if info, err = repo.fs.Lstat(name); err != nil {
+			return
+		}
+		path := filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			var target string
+			if target, err = repo.fs.Readlink(name); err != nil {
+				return
+			}
+			if err = os.Symlink(target, path); err != nil {
+				return
+			}
+			continue
+		}
+		if err = copyFile(repo, name, path, info.Mode()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// copyFile copies the repo's file to path on disk
+func copyFile(repo *Repo, name string, path string, mode os.FileMode) (err error) {
+	reader, err := repo.fs.Open(name)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	writer, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return
+	}
+	defer writer.Close()
+	_, err = io.Copy(writer, reader)
+	return
+}
+
+// offlineRefs returns the refs known for the URL from the cache and vendor
+// directory, with the vendored ones taking precedence
+func offlineRefs(url string) (refs memory.ReferenceStorage, err error) {
+	refs = make(memory.ReferenceStorage)
+
+	if root := common.CacheDir(); root != "" {
+		dir := CachePath(root, url)
+		if _, err = os.Stat(dir); err == nil {
+			store := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+			var iter storer.ReferenceIter
+			if iter, err = store.IterReferences(); err != nil {
+				return
+			}
+			err = iter.ForEach(func(ref *plumbing.Reference) error {
+				refs[ref.Name()] = ref
+				return nil
+			})
+			store.Close()
+			if err != nil {
+				return
+			}
+		}
+		err = nil
+	}
+
+	vendored, err := vendoredFor(url)
+	if err != nil {
+		return
+	}
+	for _, entry := range vendored {
+		name := plumbing.ReferenceName(entry.Resolved)
+		refs[name] = plumbing.NewHashReference(name, plumbing.NewHash(entry.Commit))
+		if entry.Ref == "" {
+			refs[plumbing.HEAD] = plumbing.NewSymbolicReference(plumbing.HEAD, name)
+		}
+	}
+
+	if len(refs) == 0 {
+		err = fmt.Errorf("%w: %s isn't vendored or cached", gitutil.ErrOffline, url)
+	}
+	return
+}
+
+// vendoredFor returns the vendor directory's entries for the URL
+func vendoredFor(url string) (vendored []Vendored, err error) {
+	dir := common.VendorDir()
+	if dir == "" {
+		return
+	}
+	all, err := ReadVendor(dir)
+	if err != nil {
+		return
+	}
+	normal := gitutil.NormalizeURL(url)
+	for _, entry := range all {
+		if gitutil.NormalizeURL(entry.URL) == normal {
+			vendored = append(vendored, entry)
+		}
+	}
+	return
+}
+
+// cloneVendored copies the vendored snapshot of the resolved commit into the
+// memory filesystem, returning false if there isn't one
+func (repo *Repo) cloneVendored() (ok bool, err error) {
+	vendored, err := vendoredFor(repo.URL)
+	if err != nil {
+		return
+	}
+	for _, entry := range vendored {
+		if entry.Commit != repo.advertised.String() {
+			continue
+		}
+		root := filepath.Join(common.VendorDir(), entry.Path)
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			name, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			name = filepath.ToSlash(name)
+			if info.Mode()&os.ModeSymlink != 0 {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				return repo.fs.Symlink(target, name)
+			}
+			return repo.load(path, name, info.Mode())
+		})
+		if err != nil {
+			return
+		}
+		repo.commit = repo.advertised
+		return true, nil
+	}
+	return
+}
+
+// load copies the file at path on disk to name in the memory filesystem
+func (repo *Repo) load(path string, name string, mode os.FileMode) (err error) {
+	reader, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	writer, err := repo.fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return
+	}
+	defer writer.Close()
+	_, err = io.Copy(writer, reader)
+	return
+}
@@ -0,0 +1,125 @@
+package repos_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shakefu/commonrepo/pkg/common"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+	. "github.com/shakefu/commonrepo/pkg/repos"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestVendor(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	// Pretend the local repository is a remote so it isn't loaded directly
+	const URL = "https://example.invalid/commonrepo"
+
+	g.Describe("vendor", func() {
+		var dir string
+		var local *Repo
+		var vendored []Vendored
+
+		g.Before(func() {
+			var err error
+			if dir, err = os.MkdirTemp("", "commonrepo-vendor-"); err != nil {
+				g.FailNow()
+			}
+			if local, err = GetLocalRepo(); err != nil {
+				g.FailNow()
+			}
+			local.URL = URL
+			if vendored, err = Vendor(dir, local); err != nil {
+				g.FailNow()
+			}
+		})
+
+		g.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.Describe("Vendor", func() {
+			g.It("indexes the resolved upstreams", func() {
+				Expect(vendored).To(HaveLen(1))
+				Expect(vendored[0].URL).To(Equal(URL))
+				Expect(vendored[0].Ref).To(BeEmpty())
+				Expect(vendored[0].Resolved).To(Equal(local.Resolved().String()))
+				Expect(vendored[0].Commit).To(Equal(local.Commit().String()))
+				Expect(ReadVendor(dir)).To(Equal(vendored))
+			})
+
+			g.It("snapshots every file", func() {
+				root := filepath.Join(dir, vendored[0].Path)
+				for _, name := range local.Files() {
+					Expect(filepath.Join(root, name)).To(BeAnExistingFile())
+				}
+				info, err := os.Stat(filepath.Join(root, "action/run.sh"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(info.Mode().Perm()).To(BeEquivalentTo(0755))
+			})
+
+			g.It("removes snapshots which aren't needed anymore", func() {
+				stale := filepath.Join(dir, "stale", "0000")
+				Expect(os.MkdirAll(stale, 0755)).To(Succeed())
+				index := filepath.Join(dir, VendorIndex)
+				Expect(os.WriteFile(index, []byte("- path: stale/0000\n"), 0644)).To(Succeed())
+
+				_, err := Vendor(dir, local)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(stale).ToNot(BeADirectory())
+				Expect(filepath.Join(dir, vendored[0].Path)).To(BeADirectory())
+				Expect(ReadVendor(dir)).To(Equal(vendored))
+			})
+		})
+
+		g.Describe("offline", func() {
+			var cache string
+
+			g.Before(func() {
+				cache = common.CacheDir()
+				common.SetOffline(true)
+				os.Setenv("COMMON_VENDOR_DIR", dir)
+				os.Setenv("COMMON_CACHE_DIR", "")
+			})
+
+			g.After(func() {
+				common.SetOffline(false)
+				os.Unsetenv("COMMON_VENDOR_DIR")
+				os.Setenv("COMMON_CACHE_DIR", cache)
+			})
+
+			g.It("loads vendored upstreams", func() {
+				repo, err := New(URL)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(repo.Commit()).To(Equal(local.Commit()))
+				Expect(repo.Resolved()).To(Equal(local.Resolved()))
+				Expect(repo.Files()).To(Equal(local.Files()))
+			})
+
+			g.It("finds vendored refs by name", func() {
+				repo, err := New(URL, local.Ref)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(repo.Commit()).To(Equal(local.Commit()))
+			})
+
+			g.It("errors for upstreams which aren't vendored", func() {
+				_, err := New("https://example.invalid/other")
+				Expect(errors.Is(err, gitutil.ErrOffline)).To(BeTrue())
+			})
+
+			g.It("still loads local repositories", func() {
+				path, err := gitutil.FindLocalRepoPath()
+				Expect(err).ToNot(HaveOccurred())
+				_, err = New(path)
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+	})
+}
@@ -0,0 +1,254 @@
+package commonrepo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+	"github.com/shakefu/commonrepo/pkg/lock"
+	"github.com/shakefu/commonrepo/pkg/repos"
+)
+
+// Action describes what writing a target would do to the local file
+type Action int
+
+const (
+	Unchanged Action = iota // The local file already matches the target
+	Create                  // The local file doesn't exist yet
+	Update                  // The local file exists with different content
+	Stale                   // The local file was written before but is no longer provided
+	Skip                    // The local file differs but its write policy keeps it
+)
+
+// String returns the lowercase name of the action
+func (action Action) String() string {
+	switch action {
+	case Unchanged:
+		return "unchanged"
+	case Create:
+		return "create"
+	case Update:
+		return "update"
+	case Stale:
+		return "stale"
+	case Skip:
+		return "skip"
+	}
+	return fmt.Sprintf("Action(%d)", int(action))
+}
+
+// Change is a single planned write of a target
+type Change struct {
+	Name     string       // Destination path, relative to the base path
+	Action   Action       // What writing the target would do
+	Upstream string       // The upstream repo providing the target
+	Target   repos.Target // The target which would be written, empty if stale
+	Content  []byte       // The rendered target content, nil if it would be deleted
+	Existing []byte       // The current local content, nil if it doesn't exist
+}
+
+// Plan is the list of changes a Write would make, sorted by name
+type Plan []Change
+
+// Plan compares the composited targets against the repository root
+func (composite Composited) Plan() (plan Plan, err error) {
+	var base string
+	if base, err = gitutil.FindLocalRepoPath(); err != nil {
+		return
+	}
+	return composite.PlanFS(osfs.New(base), "/")
+}
+
+// PlanFS compares the composited targets against the given filesystem without
+// writing anything
+func (composite Composited) PlanFS(fs billy.Filesystem, basePaths ...string) (plan Plan, err error) {
+	var base string
+	if base, err = composite.basePath(basePaths); err != nil {
+		return
+	}
+
+	// The manifest tells us what we wrote last time for the write policies
+	var manifest *lock.Manifest
+	if manifest, err = readManifest(fs, base); err != nil {
+		return
+	}
+
+	plan = make(Plan, 0, len(composite))
+	for _, name := range repos.SortTargetNames(composite) {
+		var change Change
+		if change, err = composite.planTarget(fs, base, name, manifest); err != nil {
+			return nil, err
+		}
+		plan = append(plan, change)
+	}
+
+	// Find any files we wrote previously that are no longer provided
+	var stale Plan
+	if stale, err = composite.planStale(fs, base, manifest); err != nil {
+		return nil, err
+	}
+	plan = append(plan, stale...)
+	sort.SliceStable(plan, func(i, j int) bool {
+		return plan[i].Name < plan[j].Name
+	})
+	return
+}
+
+// planStale returns the files listed in the manifest which still exist locally,
+// unchanged since we wrote them, but aren't in the composite anymore
+func (composite Composited) planStale(fs billy.Filesystem, base string, manifest *lock.Manifest) (stale Plan, err error) {
+	for _, name := range manifest.Names() {
+		if _, ok := composite[name]; ok {
+			continue
+		}
+		change := Change{
+			Name:     name,
+			Action:   Stale,
+			Upstream: manifest.Files[name].Upstream,
+		}
+		fullName := filepath.Clean(filepath.Join(base, name))
+		if change.Existing, err = util.ReadFile(fs, fullName); err != nil {
+			// It's already gone, so there's nothing to clean up
+			if os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			return
+		}
+		// The downstream changed it since we wrote it, so it's theirs now
+		if customized(manifest.Files[name], change.Existing) {
+			continue
+		}
+		// We only own the blocks in the file, so that's all that goes
+		if manifest.Files[name].Block {
+			if change.Content, err = unblock(name, change.Existing); err != nil {
+				return
+			}
+			if bytes.Equal(change.Content, change.Existing) {
+				continue
+			}
+		}
+		stale = append(stale, change)
+	}
+	return
+}
+
+// planTarget renders a single target and compares it to the local file
+func (composite Composited) planTarget(fs billy.Filesystem, base string, name string, manifest *lock.Manifest) (change Change, err error) {
+	target := composite[name]
+	change = Change{
+		Name:     name,
+		Upstream: target.Repo().String(),
+		Target:   target,
+	}
+
+	fullName := filepath.Clean(filepath.Join(base, name))
+	if change.Existing, err = util.ReadFile(fs, fullName); err != nil {
+		if !os.IsNotExist(err) {
+			return
+		}
+		change.Action = Create
+		err = nil
+	}
+
+	// Merged targets may need the local content to render
+	if change.Content, err = render(name, target, change.Existing); err != nil {
+		return
+	}
+	if change.Existing == nil {
+		return
+	}
+
+	switch {
+	case bytes.Equal(change.Content, change.Existing):
+		change.Action = Unchanged
+	case target.Policy == config.PolicyCreateOnly:
+		// The file was seeded already, it's the downstream's now
+		change.Action = Skip
+	case target.Policy == config.PolicyNeverIfModified && isModified(name, change.Existing, manifest):
+		change.Action = Skip
+	default:
+		change.Action = Update
+	}
+	return
+}
+
+// isModified returns true if the local content isn't what we last wrote, or if
+// we've never written it at all
+func isModified(name string, existing []byte, manifest *lock.Manifest) bool {
+	written, ok := manifest.Files[name]
+	return !ok || written.Hash != lock.Hash(existing)
+}
+
+// basePath returns the first of the given base paths, or the local repository
+// root if there aren't any
+func (composite Composited) basePath(basePaths []string) (string, error) {
+	if len(basePaths) > 0 {
+		return basePaths[0], nil
+	}
+	return gitutil.FindLocalRepoPath()
+}
+
+// Changed returns only the changes which would modify the local files
+func (plan Plan) Changed() Plan {
+	changed := make(Plan, 0, len(plan))
+	for _, change := range plan {
+		if change.Action != Unchanged && change.Action != Skip {
+			changed = append(changed, change)
+		}
+	}
+	return changed
+}
+
+// InSync returns true if every target already matches the local files
+func (plan Plan) InSync() bool {
+	return len(plan.Changed()) == 0
+}
+
+// Count returns the number of changes with the given action
+func (plan Plan) Count(action Action) (count int) {
+	for _, change := range plan {
+		if change.Action == action {
+			count++
+		}
+	}
+	return
+}
+
+// Summary writes a human readable summary of the plan grouped by upstream
+func (plan Plan) Summary(w io.Writer) (err error) {
+	// Group the changes by their upstream, preserving the name order within
+	// each group
+	groups := make(map[string]Plan)
+	for _, change := range plan {
+		groups[change.Upstream] = append(groups[change.Upstream], change)
+	}
+	upstreams := make([]string, 0, len(groups))
+	for upstream := range groups {
+		upstreams = append(upstreams, upstream)
+	}
+	sort.Strings(upstreams)
+
+	for _, upstream := range upstreams {
+		if _, err = fmt.Fprintln(w, upstream); err != nil {
+			return
+		}
+		for _, change := range groups[upstream] {
+			if _, err = fmt.Fprintf(w, "  %-10s %s\n", change.Action, change.Name); err != nil {
+				return
+			}
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "%d to create, %d to update, %d unchanged, %d stale, %d skipped\n",
+		plan.Count(Create), plan.Count(Update), plan.Count(Unchanged), plan.Count(Stale), plan.Count(Skip))
+	return
+}
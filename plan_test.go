@@ -0,0 +1,173 @@
+package commonrepo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/lock"
+
+	. "github.com/onsi/gomega"
+	"github.com/shakefu/goblin"
+)
+
+func TestPlan(t *testing.T) {
+	// Initialize the Goblin test suite
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) }) // Gomega hook
+
+	g.Describe("Plan", func() {
+		var composite Composited
+		var fs billy.Filesystem
+
+		g.Before(func() {
+			cr, err := NewFrom("testdata/fixtures/local/single.yml", ".")
+			if err != nil {
+				g.FailNow()
+			}
+			if err = cr.Init(); err != nil {
+				g.FailNow()
+			}
+			composite = cr.Composite()
+		})
+
+		g.BeforeEach(func() {
+			fs = memfs.New()
+		})
+
+		g.It("creates everything in an empty filesystem", func() {
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan).To(HaveLen(3))
+			Expect(plan.Count(Create)).To(Equal(3))
+			Expect(plan[0].Name).To(Equal("testdata/out/.not_commonrepo.yml"))
+			Expect(plan[0].Existing).To(BeNil())
+		})
+
+		g.It("doesn't write anything", func() {
+			_, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			found, err := fs.ReadDir("/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(BeEmpty())
+		})
+
+		g.It("classifies updates and unchanged files", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			err := util.WriteFile(fs, "testdata/out/single.yml", []byte("changed"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.Count(Unchanged)).To(Equal(2))
+			changed := plan.Changed()
+			Expect(changed).To(HaveLen(1))
+			Expect(changed[0].Name).To(Equal("testdata/out/single.yml"))
+			Expect(changed[0].Action).To(Equal(Update))
+			Expect(string(changed[0].Existing)).To(Equal("changed"))
+		})
+
+		g.It("is in sync once written", func() {
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.InSync()).To(BeFalse())
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			plan, err = composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.InSync()).To(BeTrue())
+		})
+
+		g.It("finds stale files from the manifest", func() {
+			Expect(composite.WriteFS(fs, "/")).To(Succeed())
+			smaller := Composited{}
+			for name, target := range composite {
+				smaller[name] = target
+			}
+			delete(smaller, "testdata/out/empty.yml")
+
+			plan, err := smaller.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan).To(HaveLen(3))
+			Expect(plan[1].Name).To(Equal("testdata/out/empty.yml"))
+			Expect(plan[1].Action).To(Equal(Stale))
+			target := composite["testdata/out/empty.yml"]
+			Expect(plan[1].Upstream).To(Equal(target.Repo().String()))
+			Expect(plan.InSync()).To(BeFalse())
+		})
+
+		g.Describe("with policies", func() {
+			var name = "testdata/out/single.yml"
+			var policied Composited
+
+			withPolicy := func(policy config.Policy) Composited {
+				policied = Composited{}
+				for key, target := range composite {
+					policied[key] = target
+				}
+				target := policied[name]
+				target.Policy = policy
+				policied[name] = target
+				return policied
+			}
+
+			g.It("creates create-only files", func() {
+				plan, err := withPolicy(config.PolicyCreateOnly).PlanFS(fs, "/")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(plan.Count(Create)).To(Equal(3))
+			})
+
+			g.It("skips existing create-only files", func() {
+				Expect(util.WriteFile(fs, name, []byte("mine"), 0644)).To(Succeed())
+				plan, err := withPolicy(config.PolicyCreateOnly).PlanFS(fs, "/")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(plan[2].Action).To(Equal(Skip))
+				Expect(plan.InSync()).To(BeFalse())
+				Expect(plan.Changed()).To(HaveLen(2))
+			})
+
+			g.It("updates never-if-modified files it wrote", func() {
+				Expect(composite.WriteFS(fs, "/")).To(Succeed())
+				manifest, err := lock.ReadManifest(fs, lock.ManifestFileName)
+				Expect(err).ToNot(HaveOccurred())
+				// Pretend the upstream used to provide something else
+				Expect(util.WriteFile(fs, name, []byte("old"), 0644)).To(Succeed())
+				file := manifest.Files[name]
+				file.Hash = lock.Hash([]byte("old"))
+				manifest.Files[name] = file
+				Expect(manifest.Write(fs, lock.ManifestFileName)).To(Succeed())
+
+				plan, err := withPolicy(config.PolicyNeverIfModified).PlanFS(fs, "/")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(plan[2].Action).To(Equal(Update))
+			})
+
+			g.It("skips never-if-modified files changed locally", func() {
+				Expect(composite.WriteFS(fs, "/")).To(Succeed())
+				Expect(util.WriteFile(fs, name, []byte("mine"), 0644)).To(Succeed())
+				plan, err := withPolicy(config.PolicyNeverIfModified).PlanFS(fs, "/")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(plan[2].Action).To(Equal(Skip))
+			})
+
+			g.It("doesn't write skipped files", func() {
+				Expect(util.WriteFile(fs, name, []byte("mine"), 0644)).To(Succeed())
+				Expect(withPolicy(config.PolicyCreateOnly).WriteFS(fs, "/")).To(Succeed())
+				data, err := util.ReadFile(fs, name)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal("mine"))
+			})
+		})
+
+		g.It("summarizes by upstream", func() {
+			plan, err := composite.PlanFS(fs, "/")
+			Expect(err).ToNot(HaveOccurred())
+			var buf bytes.Buffer
+			Expect(plan.Summary(&buf)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("  create     testdata/out/single.yml\n"))
+			Expect(buf.String()).To(HaveSuffix("3 to create, 0 to update, 0 unchanged, 0 stale, 0 skipped\n"))
+		})
+	})
+}
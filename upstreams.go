@@ -0,0 +1,383 @@
+package commonrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+
+	"github.com/shakefu/commonrepo/pkg/config"
+	"github.com/shakefu/commonrepo/pkg/gitutil"
+	"github.com/shakefu/commonrepo/pkg/repos"
+)
+
+// ErrUpstreamCycle is returned when upstreams inherit from each other
+var ErrUpstreamCycle = errors.New("upstream cycle")
+
+// ErrUpstreamOrder is returned when upstreams inherit shared upstreams in
+// orders which contradict each other, so there's no single order to apply them
+var ErrUpstreamOrder = errors.New("inconsistent upstream order")
+
+// ErrLimitExceeded is returned when loading upstreams goes over one of the
+// limits on upstreams, files, bytes or clone time
+var ErrLimitExceeded = errors.New("upstream limit exceeded")
+
+// loader clones an upstream tree, sharing a single CommonRepo between every
+// downstream which inherits the same upstream with the same rules
+type loader struct {
+	sync.Mutex
+	loading sync.WaitGroup
+	clones  map[string]*clone      // Upstreams by URL, ref and rules, before cloning
+	loaded  map[string]*CommonRepo // Upstreams by identity and rules, after cloning
+	root    string                 // Identity of the root
+	errs    error
+	// Limits from the root, and the totals so far
+	maxUpstreams int
+	maxFiles     int
+	maxBytes     int64
+	timeout      time.Duration
+	files        int
+	bytes        int64
+}
+
+// clone is an upstream which is being, or has been, cloned
+type clone struct {
+	done chan struct{}
+	cr   *CommonRepo
+	err  error
+}
+
+// newLoader returns a loader which already knows about the root, so an
+// upstream inheriting it is recognized as a cycle
+func newLoader(root *CommonRepo) *loader {
+	return &loader{
+		clones:       make(map[string]*clone),
+		loaded:       map[string]*CommonRepo{root.identity(): root},
+		root:         root.identity(),
+		maxUpstreams: root.MaxUpstreams,
+		maxFiles:     root.MaxFiles,
+		maxBytes:     root.MaxBytes,
+		timeout:      root.CloneTimeout,
+	}
+}
+
+// load clones the upstreams of cr concurrently, and recursively loads any
+// which haven't been loaded through another downstream
+func (load *loader) load(cr *CommonRepo, depth int) {
+	// Terminating condition, we delved too greedily and too deep and awoke the
+	// flame in the darkness
+	if depth < 1 {
+		load.fail(errors.New("maximum recursion depth reached"))
+		return
+	}
+
+	// Terminating condition, we don't have any Upstream repositories to clone
+	if len(cr.config.Upstream) == 0 {
+		return
+	}
+
+	// Preallocate the list of upstreams, each goroutine only sets its own index
+	cr.upstreams = make([]*CommonRepo, len(cr.config.Upstream))
+
+	for i, upstream := range cr.config.Upstream {
+		load.loading.Add(1)
+		go func(upstream config.Upstream, i int) {
+			defer load.loading.Done()
+
+			found, first, err := load.clone(upstream)
+			if err != nil {
+				load.fail(err)
+				return
+			}
+			cr.upstreams[i] = found
+
+			// Only the first downstream to find an upstream descends into it
+			if first {
+				load.load(found, depth-1)
+			}
+		}(upstream, i)
+	}
+}
+
+// clone returns the CommonRepo for the upstream, cloning it unless another
+// downstream already has. The first return is true if this is the first time
+// the upstream was seen.
+func (load *loader) clone(upstream config.Upstream) (cr *CommonRepo, first bool, err error) {
+	key := cloneKey(upstream)
+
+	load.Lock()
+	pending, ok := load.clones[key]
+	if !ok {
+		if load.maxUpstreams > 0 && len(load.clones) >= load.maxUpstreams {
+			load.Unlock()
+			return nil, false, fmt.Errorf("%w: cloning %s would exceed %d upstreams",
+				ErrLimitExceeded, describe(upstream), load.maxUpstreams)
+		}
+		pending = &clone{done: make(chan struct{})}
+		load.clones[key] = pending
+	}
+	load.Unlock()
+
+	// Someone else is cloning it, and will report any error
+	if ok {
+		<-pending.done
+		return pending.cr, false, nil
+	}
+	defer close(pending.done)
+
+	var repo *repos.Repo
+	if repo, pending.err = load.fetch(upstream); pending.err != nil {
+		return nil, false, pending.err
+	}
+
+	// Try to find a commonrepo config file, while applying the renames we have
+	// defined in the parent's config for this upstream, if any
+	if pending.cr, pending.err = NewFromRename(repo, upstream.Rename); pending.err != nil {
+		return nil, false, pending.err
+	}
+
+	// A different ref may still have resolved to an upstream we've already
+	// loaded, which is shared as long as the downstream adds the same rules.
+	// Inheriting the root is a cycle whatever the rules are.
+	load.Lock()
+	defer load.Unlock()
+	identity := pending.cr.identity()
+	if identity != load.root {
+		identity += rulesKey(upstream)
+	}
+	if existing, ok := load.loaded[identity]; ok {
+		pending.cr = existing
+		return existing, false, nil
+	}
+	load.loaded[identity] = pending.cr
+	return pending.cr, true, nil
+}
+
+// fetch clones the upstream within the clone timeout, and adds its files and
+// bytes to the totals
+func (load *loader) fetch(upstream config.Upstream) (repo *repos.Repo, err error) {
+	ctx := context.Background()
+	if load.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, load.timeout)
+		defer cancel()
+	}
+
+	repo = &repos.Repo{URL: upstream.URL, Ref: upstream.Ref, Prerelease: upstream.Prerelease}
+	if err = repo.LoadContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("%w: cloning %s took longer than %s",
+				ErrLimitExceeded, describe(upstream), load.timeout)
+		}
+		return
+	}
+
+	var size int64
+	if size, err = repo.Size(); err != nil {
+		return
+	}
+
+	load.Lock()
+	defer load.Unlock()
+	load.files += len(repo.Files())
+	load.bytes += size
+	switch {
+	case load.maxFiles > 0 && load.files > load.maxFiles:
+		err = fmt.Errorf("%w: %s brings the upstreams to %d files, over the limit of %d",
+			ErrLimitExceeded, describe(upstream), load.files, load.maxFiles)
+	case load.maxBytes > 0 && load.bytes > load.maxBytes:
+		err = fmt.Errorf("%w: %s brings the upstreams to %d bytes, over the limit of %d",
+			ErrLimitExceeded, describe(upstream), load.bytes, load.maxBytes)
+	}
+	return
+}
+
+// fail records an error from loading, unless the same error was already
+// recorded through another downstream
+func (load *loader) fail(err error) {
+	load.Lock()
+	defer load.Unlock()
+	for _, each := range multierr.Errors(load.errs) {
+		if each.Error() == err.Error() {
+			return
+		}
+	}
+	load.errs = multierr.Append(load.errs, err)
+}
+
+// describe returns the upstream's URL and ref for errors
+func describe(upstream config.Upstream) string {
+	if upstream.Ref == "" {
+		return upstream.URL
+	}
+	return upstream.URL + "@" + upstream.Ref
+}
+
+// cloneKey returns what's known about an upstream before cloning it which
+// determines what will be loaded
+func cloneKey(upstream config.Upstream) string {
+	key := gitutil.NormalizeURL(upstream.URL) + "@" + upstream.Ref
+	if upstream.Version != nil && upstream.Prerelease {
+		key += "+prerelease"
+	}
+	return key + rulesKey(upstream)
+}
+
+// rulesKey returns the rules the downstream adds to the upstream, since the
+// same upstream inherited with different rules can't be shared
+func rulesKey(upstream config.Upstream) (key string) {
+	for _, rename := range upstream.Rename {
+		key += "\x00rename:" + rename.String()
+	}
+	for _, include := range upstream.Include {
+		key += "\x00include:" + include
+	}
+	for _, exclude := range upstream.Exclude {
+		key += "\x00exclude:" + exclude
+	}
+	if upstream.Policy != "" {
+		key += "\x00policy:" + string(upstream.Policy)
+	}
+	for _, rule := range upstream.Policies {
+		key += "\x00policies:" + rule.String()
+	}
+	return
+}
+
+// identity returns what makes an upstream the same as another: the repository,
+// the commit it resolved to, and which config file in it was loaded, since
+// renames can pick a different one from the same commit
+func (cr *CommonRepo) identity() string {
+	return fmt.Sprintf("%s@%s:%s", gitutil.NormalizeURL(cr.repo.URL), cr.repo.Commit(), cr.from)
+}
+
+// cycle returns an ErrUpstreamCycle with the full path of the first cycle
+// found in the loaded upstreams, if there is one
+func (cr *CommonRepo) cycle() error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*CommonRepo]int)
+	path := []*CommonRepo{}
+
+	var visit func(node *CommonRepo) error
+	visit = func(node *CommonRepo) error {
+		switch state[node] {
+		case visited:
+			return nil
+		case visiting:
+			// Report the path from where the cycle starts back around to it
+			start := 0
+			for i, each := range path {
+				if each == node {
+					start = i
+				}
+			}
+			names := make([]string, 0, len(path)-start+1)
+			for _, each := range path[start:] {
+				names = append(names, each.String())
+			}
+			names = append(names, node.String())
+			return fmt.Errorf("%w: %s", ErrUpstreamCycle, strings.Join(names, " -> "))
+		}
+
+		state[node] = visiting
+		path = append(path, node)
+		for _, upstream := range node.upstreams {
+			if upstream == nil {
+				continue
+			}
+			if err := visit(upstream); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[node] = visited
+		return nil
+	}
+	return visit(cr)
+}
+
+// linearize returns cr and every upstream it inherits exactly once, from the
+// most derived to the least, using the C3 linearization. An upstream always
+// comes before everything it inherits, and later upstreams in a config come
+// before earlier ones, since they take precedence.
+func (cr *CommonRepo) linearize(memo map[*CommonRepo][]*CommonRepo) (order []*CommonRepo, err error) {
+	if found, ok := memo[cr]; ok {
+		return found, nil
+	}
+
+	// Direct upstreams by precedence, keeping the last of any listed twice
+	direct := []*CommonRepo{}
+	seen := make(map[*CommonRepo]bool)
+	for i := len(cr.upstreams) - 1; i >= 0; i-- {
+		if upstream := cr.upstreams[i]; upstream != nil && !seen[upstream] {
+			seen[upstream] = true
+			direct = append(direct, upstream)
+		}
+	}
+
+	sequences := make([][]*CommonRepo, 0, len(direct)+1)
+	for _, upstream := range direct {
+		var inherited []*CommonRepo
+		if inherited, err = upstream.linearize(memo); err != nil {
+			return
+		}
+		sequences = append(sequences, append([]*CommonRepo{}, inherited...))
+	}
+	sequences = append(sequences, direct)
+
+	order = []*CommonRepo{cr}
+	for {
+		// Drop the exhausted sequences
+		remaining := sequences[:0]
+		for _, sequence := range sequences {
+			if len(sequence) > 0 {
+				remaining = append(remaining, sequence)
+			}
+		}
+		sequences = remaining
+		if len(sequences) == 0 {
+			break
+		}
+
+		// Find the first head which isn't inherited by anything still left
+		var next *CommonRepo
+		for _, sequence := range sequences {
+			if !inTail(sequences, sequence[0]) {
+				next = sequence[0]
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUpstreamOrder, cr)
+		}
+
+		order = append(order, next)
+		for i, sequence := range sequences {
+			if sequence[0] == next {
+				sequences[i] = sequence[1:]
+			}
+		}
+	}
+
+	memo[cr] = order
+	return
+}
+
+// inTail returns true if the upstream is in any sequence after its head
+func inTail(sequences [][]*CommonRepo, upstream *CommonRepo) bool {
+	for _, sequence := range sequences {
+		for _, each := range sequence[1:] {
+			if each == upstream {
+				return true
+			}
+		}
+	}
+	return false
+}
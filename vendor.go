@@ -0,0 +1,30 @@
+package commonrepo
+
+import (
+	"errors"
+	"os"
+
+	"github.com/shakefu/commonrepo/pkg/repos"
+)
+
+// Vendor writes a snapshot of every upstream to the directory, along with an
+// index of what each upstream's URL and ref resolved to, so they can be loaded
+// without network access by running offline. Init must be called first.
+func (cr *CommonRepo) Vendor(dir string) (vendored []repos.Vendored, err error) {
+	if len(cr.flattened) == 0 {
+		return nil, errors.New("upstreams not initialized")
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	upstreams := make([]*repos.Repo, 0, len(cr.flattened))
+	for _, each := range cr.flattened {
+		// We're the local repository, not an upstream
+		if each == cr {
+			continue
+		}
+		upstreams = append(upstreams, each.repo)
+	}
+	return repos.Vendor(dir, upstreams...)
+}